@@ -0,0 +1,67 @@
+// Package blobstore keeps the claim checked payloads of push and dlq
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/unee-t/lambda2sqs/config"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// S3Store keeps oversized payloads in CLAIM_CHECK_BUCKET
+type S3Store struct {
+	Svc    *s3.Client
+	Bucket string
+}
+
+// Put stores body under key
+func (s S3Store) Put(ctx context.Context, key string, body []byte) error {
+	req := s.Svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	_, err := req.Send(ctx)
+	return err
+}
+
+// Get reads the payload stored under key
+func (s S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	req := s.Svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	res, err := req.Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// Delete removes the payload stored under key
+func (s S3Store) Delete(ctx context.Context, key string) error {
+	req := s.Svc.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	_, err := req.Send(ctx)
+	return err
+}
+
+// New prefers CLAIM_CHECK_BUCKET, then CLAIM_CHECK_DIR for local dev, nil
+// when neither is configured
+func New(cfg aws.Config, c config.ClaimCheck) payload.BlobStore {
+	if c.Bucket != "" {
+		return S3Store{Svc: s3.New(cfg), Bucket: c.Bucket}
+	}
+	if c.Dir != "" {
+		return payload.DirStore{Dir: c.Dir}
+	}
+	return nil
+}
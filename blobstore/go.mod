@@ -0,0 +1,16 @@
+module github.com/unee-t/lambda2sqs/blobstore
+
+go 1.12
+
+require (
+	github.com/aws/aws-sdk-go-v2 v0.11.0
+	github.com/stretchr/testify v1.4.0 // indirect
+	github.com/unee-t/lambda2sqs/config v0.0.0
+	github.com/unee-t/lambda2sqs/payload v0.0.0
+	golang.org/x/net v0.0.0-20190912160710-24e19bdeb0f2 // indirect
+	golang.org/x/text v0.3.2 // indirect
+)
+
+replace github.com/unee-t/lambda2sqs/payload => ../payload
+
+replace github.com/unee-t/lambda2sqs/config => ../config
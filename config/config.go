@@ -0,0 +1,275 @@
+// Package config is the configuration of push and process
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// Config holds every setting of push and process. Each comes from, in
+// increasing precedence, its default, the CONFIG_FILE JSON file and the
+// environment variable in its env tag. Settings with an ssm tag that are
+// still empty are then read from the unee-t SSM secrets by Resolve.
+type Config struct {
+	Stage       string     `json:"stage" env:"STAGE"`
+	ActionTypes string     `json:"actionTypes" env:"ACTION_TYPES"`
+	ClaimCheck  ClaimCheck `json:"claimCheck"`
+	Push        Push       `json:"push"`
+	Process     Process    `json:"process"`
+}
+
+// ClaimCheck is where the payloads too large for SQS are kept
+type ClaimCheck struct {
+	Bucket string `json:"bucket" env:"CLAIM_CHECK_BUCKET"`
+	// Dir is a local directory for development, when Bucket is empty
+	Dir string `json:"dir" env:"CLAIM_CHECK_DIR"`
+}
+
+// Push is the configuration of push
+type Push struct {
+	QueueURL string `json:"queueUrl" env:"SQS_URL"`
+	// FIFO switches on MessageDeduplicationId & MessageGroupId, which FIFO
+	// queues require and standard queues reject
+	FIFO     bool   `json:"fifo" env:"SQS_FIFO"`
+	TopicARN string `json:"topicArn" env:"SNS_TOPIC_ARN"`
+	Routes   string `json:"routes" env:"ROUTES"`
+	// DecodingSpec lists the base64 encoded fields
+	DecodingSpec string `json:"decodingSpec" env:"DECODING_SPEC"`
+	// DecodingStrict rejects payloads with unmarked fields that look base64 encoded
+	DecodingStrict bool `json:"decodingStrict" env:"DECODING_STRICT"`
+}
+
+// Process is the configuration of process
+type Process struct {
+	MEFE               MEFE        `json:"mefe"`
+	DB                 DB          `json:"db"`
+	Idempotency        Idempotency `json:"idempotency"`
+	DeadLetterQueueURL string      `json:"deadLetterQueueUrl" env:"DEAD_LETTER_QUEUE_URL"`
+	// DryRun logs the MEFE requests and reply calls instead of making them
+	DryRun bool `json:"dryRun" env:"DRY_RUN"`
+}
+
+// MEFE is the MEFE API
+type MEFE struct {
+	// BaseURL is https://case.<stage domain> when empty
+	BaseURL     string           `json:"baseUrl" env:"MEFE_BASE_URL"`
+	AccessToken Secret           `json:"accessToken" env:"API_ACCESS_TOKEN" ssm:"API_ACCESS_TOKEN"`
+	Timeout     payload.Duration `json:"timeout" env:"MEFE_TIMEOUT"`
+}
+
+// DB is the enterprise DB
+type DB struct {
+	Host            string           `json:"host" env:"UNTEDB_HOST" ssm:"UNTEDB_HOST"`
+	Port            int              `json:"port" env:"DB_PORT"`
+	Name            string           `json:"name" env:"DB_NAME"`
+	User            string           `json:"user" env:"LAMBDA_INVOKER_USERNAME" ssm:"LAMBDA_INVOKER_USERNAME"`
+	Password        Secret           `json:"password" env:"LAMBDA_INVOKER_PASSWORD" ssm:"LAMBDA_INVOKER_PASSWORD"`
+	ConnectTimeout  payload.Duration `json:"connectTimeout" env:"DB_CONNECT_TIMEOUT"`
+	PingTimeout     payload.Duration `json:"pingTimeout" env:"DB_PING_TIMEOUT"`
+	MaxOpenConns    int              `json:"maxOpenConns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int              `json:"maxIdleConns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime payload.Duration `json:"connMaxLifetime" env:"DB_CONN_MAX_LIFETIME"`
+}
+
+// Idempotency is the table of processed messages, off when Table is empty
+type Idempotency struct {
+	Table     string           `json:"table" env:"IDEMPOTENCY_TABLE"`
+	Retention payload.Duration `json:"retention" env:"IDEMPOTENCY_RETENTION"`
+}
+
+// Default returns the settings used unless configured otherwise
+func Default() Config {
+	var c Config
+	c.Process.MEFE.Timeout = payload.Duration{Duration: 8 * time.Second}
+	c.Process.DB.Port = 3306
+	c.Process.DB.Name = "unee_t_enterprise"
+	c.Process.DB.ConnectTimeout = payload.Duration{Duration: 5 * time.Second}
+	c.Process.DB.PingTimeout = payload.Duration{Duration: 3 * time.Second}
+	c.Process.DB.MaxOpenConns = 5
+	c.Process.DB.MaxIdleConns = 2
+	c.Process.DB.ConnMaxLifetime = payload.Duration{Duration: 5 * time.Minute}
+	// SQS keeps a message for 14 days at most
+	c.Process.Idempotency.Retention = payload.Duration{Duration: 14 * 24 * time.Hour}
+	return c
+}
+
+// Load returns the Default settings overridden by CONFIG_FILE and then the
+// environment, getenv being os.Getenv
+func Load(getenv func(string) string) (Config, error) {
+	c := Default()
+	if file := getenv("CONFIG_FILE"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return c, err
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return c, fmt.Errorf("CONFIG_FILE %s: %v", file, err)
+		}
+	}
+	err := walk(&c, func(f reflect.Value, field reflect.StructField) error {
+		name := field.Tag.Get("env")
+		if name == "" {
+			return nil
+		}
+		if s := getenv(name); s != "" {
+			if err := set(f, s); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		return nil
+	})
+	return c, err
+}
+
+// Secrets are the unee-t env SSM parameters and stage domains, github.com/unee-t/env.Env
+type Secrets interface {
+	GetSecret(key string) string
+	Udomain(service string) string
+}
+
+// Resolve reads the empty ssm settings from s in parallel and derives the
+// MEFE base URL from the stage domain unless it is overridden
+func (c *Config) Resolve(s Secrets) error {
+	type secret struct {
+		key   string
+		field reflect.Value
+		value string
+	}
+	var missing []*secret
+	walk(c, func(f reflect.Value, field reflect.StructField) error {
+		if key := field.Tag.Get("ssm"); key != "" && f.String() == "" {
+			missing = append(missing, &secret{key: key, field: f})
+		}
+		return nil
+	})
+	var wg sync.WaitGroup
+	for _, m := range missing {
+		wg.Add(1)
+		go func(m *secret) {
+			defer wg.Done()
+			m.value = s.GetSecret(m.key)
+		}(m)
+	}
+	wg.Wait()
+	for _, m := range missing {
+		if m.value == "" {
+			return fmt.Errorf("failed to retrieve %s", m.key)
+		}
+		m.field.SetString(m.value)
+	}
+	if c.Process.MEFE.BaseURL == "" {
+		c.Process.MEFE.BaseURL = "https://" + s.Udomain("case")
+	}
+	return nil
+}
+
+// ValidatePush checks the settings push needs
+func (c Config) ValidatePush() error {
+	if c.Push.QueueURL == "" && c.Push.TopicARN == "" && c.Push.Routes == "" {
+		return fmt.Errorf("push has nowhere to send: set SQS_URL, SNS_TOPIC_ARN or ROUTES")
+	}
+	return nil
+}
+
+// ValidateProcess checks the settings process needs, once resolved
+func (c Config) ValidateProcess() error {
+	p := c.Process
+	u, err := url.Parse(p.MEFE.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("MEFE_BASE_URL %q is not an http(s) URL", p.MEFE.BaseURL)
+	}
+	switch {
+	case p.DB.Host == "":
+		return fmt.Errorf("missing UNTEDB_HOST")
+	case p.DB.Port <= 0 || p.DB.Port > 65535:
+		return fmt.Errorf("DB_PORT %d out of range", p.DB.Port)
+	case p.DB.Name == "":
+		return fmt.Errorf("missing DB_NAME")
+	case p.DB.MaxOpenConns < 0 || p.DB.MaxIdleConns < 0:
+		return fmt.Errorf("negative DB connections")
+	case p.MEFE.Timeout.Duration <= 0 || p.DB.ConnectTimeout.Duration <= 0 || p.DB.PingTimeout.Duration <= 0:
+		return fmt.Errorf("timeouts must be positive")
+	case p.Idempotency.Table != "" && p.Idempotency.Retention.Duration <= 0:
+		return fmt.Errorf("IDEMPOTENCY_RETENTION must be positive")
+	}
+	return nil
+}
+
+// Summary is the configuration as JSON for the startup log, secrets redacted
+func (c Config) Summary() json.RawMessage {
+	out, _ := json.Marshal(c)
+	return out
+}
+
+// walk calls fn on every leaf field of the struct v points at
+func walk(v interface{}, fn func(f reflect.Value, field reflect.StructField) error) error {
+	var visit func(s reflect.Value) error
+	visit = func(s reflect.Value) error {
+		for i := 0; i < s.NumField(); i++ {
+			f, field := s.Field(i), s.Type().Field(i)
+			if f.Kind() == reflect.Struct && field.Type != reflect.TypeOf(payload.Duration{}) {
+				if err := visit(f); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := fn(f, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return visit(reflect.ValueOf(v).Elem())
+}
+
+// set parses s into the field f
+func set(f reflect.Value, s string) error {
+	switch f.Interface().(type) {
+	case payload.Duration:
+		d, err := time.ParseDuration(s)
+		f.Set(reflect.ValueOf(payload.Duration{Duration: d}))
+		return err
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(i))
+	default:
+		return fmt.Errorf("unsupported %s", f.Kind())
+	}
+	return nil
+}
+
+// Secret is a setting kept out of logs
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// MarshalJSON redacts the secret
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSecrets map[string]string
+
+func (s fakeSecrets) GetSecret(key string) string   { return s[key] }
+func (s fakeSecrets) Udomain(service string) string { return service + ".dev.unee-t.com" }
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(file, []byte(`{"process": {"db": {"name": "enterprise_test", "port": 3307}, "mefe": {"timeout": "2s"}}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"CONFIG_FILE":     file,
+		"SQS_URL":         "https://sqs.ap-southeast-1.amazonaws.com/812644853088/queue",
+		"SQS_FIFO":        "true",
+		"DB_PORT":         "3308",
+		"MEFE_BASE_URL":   "http://localhost:3000",
+		"UNTEDB_HOST":     "localhost",
+		"DB_PING_TIMEOUT": "1s",
+	}
+	c, err := Load(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := c.Process.DB
+	if !c.Push.FIFO || db.Name != "enterprise_test" || db.Port != 3308 || db.Host != "localhost" ||
+		db.PingTimeout.Duration != time.Second || db.MaxOpenConns != 5 || c.Process.MEFE.Timeout.Duration != 2*time.Second {
+		t.Errorf("Load() = %+v", c)
+	}
+	if err := c.ValidatePush(); err != nil {
+		t.Errorf("ValidatePush() error = %v", err)
+	}
+
+	err = c.Resolve(fakeSecrets{"LAMBDA_INVOKER_USERNAME": "lambda", "LAMBDA_INVOKER_PASSWORD": "hunter2", "API_ACCESS_TOKEN": "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Process.DB.Password != "hunter2" || c.Process.MEFE.BaseURL != "http://localhost:3000" || c.Process.DB.Host != "localhost" {
+		t.Errorf("Resolve() = %+v", c.Process)
+	}
+	if err := c.ValidateProcess(); err != nil {
+		t.Errorf("ValidateProcess() error = %v", err)
+	}
+	summary := string(c.Summary())
+	if strings.Contains(summary, "hunter2") || strings.Contains(summary, "token\"") || !strings.Contains(summary, `"password":"[redacted]"`) {
+		t.Errorf("Summary() = %s", summary)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c := Default()
+	if err := c.Resolve(fakeSecrets{"UNTEDB_HOST": "db", "LAMBDA_INVOKER_USERNAME": "lambda"}); err == nil {
+		t.Error("Resolve() ignored the missing secrets")
+	}
+	c = Default()
+	c.Process.DB.Password = "set"
+	c.Process.MEFE.AccessToken = "set"
+	if err := c.Resolve(fakeSecrets{"UNTEDB_HOST": "db", "LAMBDA_INVOKER_USERNAME": "lambda"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Process.MEFE.BaseURL != "https://case.dev.unee-t.com" {
+		t.Errorf("Resolve() MEFE base URL = %s", c.Process.MEFE.BaseURL)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for key, value := range map[string]string{"SQS_FIFO": "yes please", "DB_PORT": "mysql", "MEFE_TIMEOUT": "8", "CONFIG_FILE": "testdata/missing.json"} {
+		if _, err := Load(func(k string) string {
+			if k == key {
+				return value
+			}
+			return ""
+		}); err == nil {
+			t.Errorf("Load() accepted %s=%s", key, value)
+		}
+	}
+
+	c := Default()
+	c.Process.MEFE.BaseURL = "case.dev.unee-t.com"
+	c.Process.DB.Host = "db"
+	if err := c.ValidateProcess(); err == nil {
+		t.Error("ValidateProcess() accepted a base URL without scheme")
+	}
+	if err := c.ValidatePush(); err == nil {
+		t.Error("ValidatePush() accepted nowhere to send")
+	}
+}
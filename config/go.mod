@@ -0,0 +1,7 @@
+module github.com/unee-t/lambda2sqs/config
+
+go 1.12
+
+require github.com/unee-t/lambda2sqs/payload v0.0.0
+
+replace github.com/unee-t/lambda2sqs/payload => ../payload
@@ -0,0 +1,17 @@
+module github.com/unee-t/lambda2sqs/dlq
+
+go 1.12
+
+require (
+	github.com/apex/log v1.1.1
+	github.com/aws/aws-sdk-go-v2 v0.11.0
+	github.com/unee-t/lambda2sqs/blobstore v0.0.0
+	github.com/unee-t/lambda2sqs/config v0.0.0
+	github.com/unee-t/lambda2sqs/payload v0.0.0
+)
+
+replace github.com/unee-t/lambda2sqs/payload => ../payload
+
+replace github.com/unee-t/lambda2sqs/config => ../config
+
+replace github.com/unee-t/lambda2sqs/blobstore => ../blobstore
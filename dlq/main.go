@@ -0,0 +1,190 @@
+// Command dlq triages the messages process dead-lettered:
+//
+//	dlq list [-match actionType=CREATE_UNIT] [-reason MEFE]
+//	dlq inspect -id <message id>
+//	dlq edit -id <message id> [-file payload.json] [-redrive]
+//	dlq redrive -id <id>,<id> | -match key=value | -reason text | -all
+//
+// It reads DEAD_LETTER_QUEUE_URL and SQS_URL like process and push do.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/text"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/blobstore"
+	"github.com/unee-t/lambda2sqs/config"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+var conf config.Config
+
+// listFlag collects repeated or comma separated values
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// matchFlag collects repeated key=value pairs
+type matchFlag map[string]string
+
+func (m matchFlag) String() string { return fmt.Sprint(map[string]string(m)) }
+
+func (m matchFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("want key=value, got %q", s)
+	}
+	m[parts[0]] = parts[1]
+	return nil
+}
+
+func main() {
+	log.SetHandler(text.New(os.Stderr))
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: dlq list|inspect|edit|redrive [flags]")
+		os.Exit(2)
+	}
+	var err error
+	conf, err = config.Load(os.Getenv)
+	if err != nil {
+		log.WithError(err).Fatal("failed to load config")
+	}
+	defs, err := payload.LoadActionDefinitions(conf.ActionTypes)
+	if err != nil {
+		log.WithError(err).Fatal("failed to load ACTION_TYPES")
+	}
+	payload.Define(defs)
+	if err := run(context.Background(), os.Args[1], os.Args[2:]); err != nil {
+		log.WithError(err).Fatal(os.Args[1])
+	}
+}
+
+func run(ctx context.Context, cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	dlqURL := fs.String("dlq", conf.Process.DeadLetterQueueURL, "dead letter queue URL")
+	to := fs.String("to", conf.Push.QueueURL, "queue URL to redrive to")
+	max := fs.Int("max", 1000, "most messages to look at")
+	visibility := fs.Duration("visibility", 5*time.Minute, "how long looked at messages stay hidden, should the command die")
+	var ids listFlag
+	fs.Var(&ids, "id", "message IDs, repeated or comma separated")
+	match := matchFlag{}
+	fs.Var(match, "match", "key=value an attribute or top-level payload field must have, repeated")
+	reason := fs.String("reason", "", "text the last error must contain")
+	all := fs.Bool("all", false, "redrive every message")
+	file := fs.String("file", "", "edited payload, - for stdin, else $EDITOR opens the payload")
+	redriveEdited := fs.Bool("redrive", false, "send the edited message to the queue instead of back to the DLQ")
+	fs.Parse(args)
+
+	if *dlqURL == "" {
+		return errors.New("no -dlq or DEAD_LETTER_QUEUE_URL")
+	}
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return err
+	}
+	blobs = blobstore.New(cfg, conf.ClaimCheck)
+	svc := sqs.New(cfg)
+	dlq := sqsQueue{svc: svc, url: *dlqURL, visibility: *visibility}
+	target := func() (Queue, error) {
+		if *to == "" {
+			return nil, errors.New("no -to or SQS_URL")
+		}
+		return sqsQueue{svc: svc, url: *to, visibility: *visibility}, nil
+	}
+	f := filter{IDs: ids, Match: match, Reason: *reason}
+
+	switch cmd {
+	case "list":
+		return list(ctx, dlq, f, *max, os.Stdout)
+	case "inspect":
+		if len(ids) != 1 {
+			return errors.New("inspect one -id")
+		}
+		return inspect(ctx, dlq, ids[0], *max, os.Stdout)
+	case "edit":
+		if len(ids) != 1 {
+			return errors.New("edit one -id")
+		}
+		var q Queue
+		if *redriveEdited {
+			if q, err = target(); err != nil {
+				return err
+			}
+		}
+		m, err := edit(ctx, dlq, q, ids[0], *max, editor(*file))
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"id": ids[0], "redriven": *redriveEdited}).Info("edited")
+		fmt.Println(m.Body)
+		return nil
+	case "redrive":
+		if f.empty() && !*all {
+			return errors.New("select messages with -id, -match or -reason, or redrive -all")
+		}
+		q, err := target()
+		if err != nil {
+			return err
+		}
+		moved, err := redrive(ctx, dlq, q, f, *max, os.Stdout)
+		log.WithFields(log.Fields{"moved": moved, "to": *to}).Info("redrive")
+		return err
+	}
+	return fmt.Errorf("unknown command %q, want list, inspect, edit or redrive", cmd)
+}
+
+// editor reads the edited payload from file, stdin for -, or lets $EDITOR
+// change it in a temporary file
+func editor(file string) func(old []byte) ([]byte, error) {
+	return func(old []byte) ([]byte, error) {
+		switch file {
+		case "-":
+			return ioutil.ReadAll(os.Stdin)
+		case "":
+		default:
+			return ioutil.ReadFile(file)
+		}
+		tmp, err := ioutil.TempFile("", "dlq-*.json")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = tmp.Write(old)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := os.Getenv("EDITOR")
+		if name == "" {
+			name = "vi"
+		}
+		cmd := exec.Command(name, tmp.Name())
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(tmp.Name())
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Message is a queued message as triage sees it
+type Message struct {
+	ID            string
+	ReceiptHandle string
+	Body          string
+	// Attributes are the string message attributes
+	Attributes map[string]string
+	// DataTypes are the SQS data types of Attributes as received, String
+	// when missing
+	DataTypes    map[string]string
+	ReceiveCount int
+	SentAt       time.Time
+	// GroupID & DeduplicationID are only set on FIFO queues
+	GroupID         string
+	DeduplicationID string
+}
+
+// Queue is the part of SQS triage needs
+type Queue interface {
+	// Receive hides up to max messages for the visibility timeout and returns them
+	Receive(ctx context.Context, max int) ([]Message, error)
+	// Release makes a received message visible again
+	Release(ctx context.Context, m Message) error
+	// Delete removes a received message
+	Delete(ctx context.Context, m Message) error
+	// Send enqueues the body and attributes of m
+	Send(ctx context.Context, m Message) error
+}
+
+// sqsQueue is a Queue on an SQS queue URL
+type sqsQueue struct {
+	svc *sqs.Client
+	url string
+	// visibility hides received messages long enough to triage them
+	visibility time.Duration
+}
+
+func (q sqsQueue) Receive(ctx context.Context, max int) ([]Message, error) {
+	if max > 10 {
+		max = 10
+	}
+	req := q.svc.ReceiveMessageRequest(&sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(q.url),
+		MaxNumberOfMessages:   aws.Int64(int64(max)),
+		VisibilityTimeout:     aws.Int64(int64(q.visibility / time.Second)),
+		WaitTimeSeconds:       aws.Int64(1),
+		AttributeNames:        []sqs.QueueAttributeName{sqs.QueueAttributeNameAll},
+		MessageAttributeNames: []string{"All"},
+	})
+	res, err := req.Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []Message
+	for _, m := range res.Messages {
+		msg := Message{
+			ID:              aws.StringValue(m.MessageId),
+			ReceiptHandle:   aws.StringValue(m.ReceiptHandle),
+			Body:            aws.StringValue(m.Body),
+			Attributes:      map[string]string{},
+			DataTypes:       map[string]string{},
+			GroupID:         m.Attributes["MessageGroupId"],
+			DeduplicationID: m.Attributes["MessageDeduplicationId"],
+		}
+		msg.ReceiveCount, _ = strconv.Atoi(m.Attributes["ApproximateReceiveCount"])
+		if ms, err := strconv.ParseInt(m.Attributes["SentTimestamp"], 10, 64); err == nil {
+			msg.SentAt = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+		}
+		for key, attr := range m.MessageAttributes {
+			if attr.StringValue != nil {
+				msg.Attributes[key] = *attr.StringValue
+				msg.DataTypes[key] = aws.StringValue(attr.DataType)
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (q sqsQueue) Release(ctx context.Context, m Message) error {
+	req := q.svc.ChangeMessageVisibilityRequest(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.url),
+		ReceiptHandle:     aws.String(m.ReceiptHandle),
+		VisibilityTimeout: aws.Int64(0),
+	})
+	_, err := req.Send(ctx)
+	return err
+}
+
+func (q sqsQueue) Delete(ctx context.Context, m Message) error {
+	req := q.svc.DeleteMessageRequest(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.url),
+		ReceiptHandle: aws.String(m.ReceiptHandle),
+	})
+	_, err := req.Send(ctx)
+	return err
+}
+
+func (q sqsQueue) Send(ctx context.Context, m Message) error {
+	_, err := q.svc.SendMessageRequest(sendMessageInput(q.url, m)).Send(ctx)
+	return err
+}
+
+// sendMessageInput sends m back with the data types its attributes were received with
+func sendMessageInput(url string, m Message) *sqs.SendMessageInput {
+	attrs := map[string]sqs.MessageAttributeValue{}
+	for key, val := range m.Attributes {
+		dataType := m.DataTypes[key]
+		if dataType == "" {
+			dataType = "String"
+		}
+		attrs[key] = sqs.MessageAttributeValue{DataType: aws.String(dataType), StringValue: aws.String(val)}
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(url),
+		MessageBody:       aws.String(m.Body),
+		MessageAttributes: attrs,
+	}
+	if m.GroupID != "" {
+		input.MessageGroupId = aws.String(m.GroupID)
+		input.MessageDeduplicationId = aws.String(m.DeduplicationID)
+	}
+	return input
+}
+
+// memoryQueue is a Queue in memory, to test triage without AWS. Received
+// messages stay hidden until released or deleted.
+type memoryQueue struct {
+	mu     sync.Mutex
+	seq    int
+	msgs   map[string]*Message
+	hidden map[string]bool
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{msgs: map[string]*Message{}, hidden: map[string]bool{}}
+}
+
+func (q *memoryQueue) Receive(ctx context.Context, max int) (out []Message, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, id := range q.ids() {
+		if len(out) == max {
+			break
+		}
+		if q.hidden[id] {
+			continue
+		}
+		m := q.msgs[id]
+		m.ReceiveCount++
+		m.ReceiptHandle = fmt.Sprintf("%s#%d", id, m.ReceiveCount)
+		q.hidden[id] = true
+		out = append(out, copyMessage(*m))
+	}
+	return out, nil
+}
+
+func (q *memoryQueue) Release(ctx context.Context, m Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, err := q.received(m); err != nil {
+		return err
+	}
+	delete(q.hidden, m.ID)
+	return nil
+}
+
+func (q *memoryQueue) Delete(ctx context.Context, m Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, err := q.received(m); err != nil {
+		return err
+	}
+	delete(q.msgs, m.ID)
+	delete(q.hidden, m.ID)
+	return nil
+}
+
+func (q *memoryQueue) Send(ctx context.Context, m Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	m = copyMessage(m)
+	m.ID = fmt.Sprintf("m%03d", q.seq)
+	m.ReceiptHandle, m.ReceiveCount, m.SentAt = "", 0, time.Now().UTC()
+	q.msgs[m.ID] = &m
+	return nil
+}
+
+// Messages returns every message, hidden ones included, in the order sent
+func (q *memoryQueue) Messages() (out []Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, id := range q.ids() {
+		out = append(out, copyMessage(*q.msgs[id]))
+	}
+	return out
+}
+
+func (q *memoryQueue) ids() (ids []string) {
+	for id := range q.msgs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// received checks m holds the current receipt of a hidden message
+func (q *memoryQueue) received(m Message) (*Message, error) {
+	cur, ok := q.msgs[m.ID]
+	if !ok || !q.hidden[m.ID] || cur.ReceiptHandle != m.ReceiptHandle {
+		return nil, fmt.Errorf("invalid receipt handle %q", m.ReceiptHandle)
+	}
+	return cur, nil
+}
+
+func copyMessage(m Message) Message {
+	attrs := make(map[string]string, len(m.Attributes))
+	for key, val := range m.Attributes {
+		attrs[key] = val
+	}
+	types := make(map[string]string, len(m.DataTypes))
+	for key, val := range m.DataTypes {
+		types[key] = val
+	}
+	m.Attributes, m.DataTypes = attrs, types
+	return m
+}
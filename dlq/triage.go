@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// blobReader fetches claim checked payloads, nil when none are configured
+type blobReader interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+var blobs blobReader
+
+// entry is a dead-lettered message with its decoded payload
+type entry struct {
+	ID string `json:"id"`
+	// ReceiveCount is how many times process received the message, else
+	// how many times the DLQ was read
+	ReceiveCount int       `json:"receiveCount"`
+	SentAt       time.Time `json:"sentAt"`
+	// LastError is the reason process dead-lettered the message. Messages
+	// moved by the redrive policy after maxReceiveCount have none, their
+	// errors are in the process logs.
+	LastError  string              `json:"lastError,omitempty"`
+	Attributes map[string]string   `json:"attributes,omitempty"`
+	ClaimCheck *payload.ClaimCheck `json:"claimCheck,omitempty"`
+	Payload    json.RawMessage     `json:"payload,omitempty"`
+	// Body is set instead of Payload when the body is not JSON
+	Body string `json:"body,omitempty"`
+
+	msg Message
+}
+
+func newEntry(ctx context.Context, m Message) entry {
+	e := entry{
+		ID:           m.ID,
+		ReceiveCount: m.ReceiveCount,
+		SentAt:       m.SentAt,
+		LastError:    m.Attributes[payload.AttrDeadLetterReason],
+		Attributes:   m.Attributes,
+		msg:          m,
+	}
+	if n, err := strconv.Atoi(m.Attributes[payload.AttrReceiveCount]); err == nil {
+		e.ReceiveCount = n
+	}
+	body := []byte(m.Body)
+	if cc := payload.ParseClaimCheck(body); cc != nil {
+		e.ClaimCheck = cc
+		if blobs == nil {
+			return e
+		}
+		blob, err := blobs.Get(ctx, cc.Key)
+		if err != nil {
+			log.WithError(err).WithField("key", cc.Key).Warn("failed to fetch claim checked payload")
+			return e
+		}
+		body = blob
+	}
+	if json.Valid(body) {
+		e.Payload = body
+	} else {
+		e.Body = string(body)
+	}
+	return e
+}
+
+// filter selects messages. The empty filter selects every message.
+type filter struct {
+	// IDs are message IDs
+	IDs []string
+	// Match holds attributes or top-level payload fields and their values
+	Match map[string]string
+	// Reason is part of the last error
+	Reason string
+}
+
+func (f filter) empty() bool {
+	return len(f.IDs) == 0 && len(f.Match) == 0 && f.Reason == ""
+}
+
+func (f filter) matches(e entry) bool {
+	if len(f.IDs) > 0 && !contains(f.IDs, e.ID) {
+		return false
+	}
+	if f.Reason != "" && !strings.Contains(e.LastError, f.Reason) {
+		return false
+	}
+	if len(f.Match) == 0 {
+		return true
+	}
+	fields := map[string]interface{}{}
+	d := json.NewDecoder(bytes.NewReader(e.Payload))
+	d.UseNumber()
+	d.Decode(&fields)
+	for key, want := range f.Match {
+		got, ok := e.Attributes[key]
+		if !ok && fields[key] != nil {
+			got, ok = fmt.Sprint(fields[key]), true
+		}
+		if !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// scan receives up to max messages of q. They stay hidden until released
+// or deleted, so each is seen once.
+func scan(ctx context.Context, q Queue, max int) (entries []entry, err error) {
+	for len(entries) < max {
+		msgs, err := q.Receive(ctx, max-len(entries))
+		if err != nil {
+			return entries, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, m := range msgs {
+			entries = append(entries, newEntry(ctx, m))
+		}
+	}
+	return entries, nil
+}
+
+// release makes the scanned messages left visible again
+func release(ctx context.Context, q Queue, entries []entry) {
+	for _, e := range entries {
+		if err := q.Release(ctx, e.msg); err != nil {
+			log.WithError(err).WithField("id", e.ID).Warn("failed to release message")
+		}
+	}
+}
+
+// list writes the messages of q that f selects as JSON lines
+func list(ctx context.Context, q Queue, f filter, max int, w io.Writer) error {
+	entries, err := scan(ctx, q, max)
+	defer release(ctx, q, entries)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if !f.matches(e) {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inspect writes the message id of q with its raw body
+func inspect(ctx context.Context, q Queue, id string, max int, w io.Writer) error {
+	entries, err := scan(ctx, q, max)
+	defer release(ctx, q, entries)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.ID != id {
+			continue
+		}
+		out, err := json.MarshalIndent(struct {
+			entry
+			RawBody         string `json:"rawBody"`
+			GroupID         string `json:"groupId,omitempty"`
+			DeduplicationID string `json:"deduplicationId,omitempty"`
+		}{e, e.msg.Body, e.msg.GroupID, e.msg.DeduplicationID}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	return fmt.Errorf("message %s not found in the first %d messages", id, max)
+}
+
+// edit replaces the payload of message id of dlq by what change makes of it.
+// The edited message goes to target when given, else back to dlq.
+func edit(ctx context.Context, dlq, target Queue, id string, max int, change func(old []byte) ([]byte, error)) (Message, error) {
+	entries, err := scan(ctx, dlq, max)
+	if err != nil {
+		release(ctx, dlq, entries)
+		return Message{}, err
+	}
+	for i, e := range entries {
+		if e.ID != id {
+			continue
+		}
+		release(ctx, dlq, append(entries[:i:i], entries[i+1:]...))
+		if e.Payload == nil {
+			release(ctx, dlq, entries[i:i+1])
+			return Message{}, fmt.Errorf("message %s has no payload to edit", id)
+		}
+		edited, err := editedMessage(e, change)
+		if err != nil {
+			release(ctx, dlq, entries[i:i+1])
+			return Message{}, err
+		}
+		if target == nil {
+			target = dlq
+		} else {
+			delete(edited.Attributes, payload.AttrDeadLetterReason)
+			delete(edited.Attributes, payload.AttrReceiveCount)
+		}
+		if err := target.Send(ctx, edited); err != nil {
+			release(ctx, dlq, entries[i:i+1])
+			return Message{}, err
+		}
+		return edited, dlq.Delete(ctx, e.msg)
+	}
+	release(ctx, dlq, entries)
+	return Message{}, fmt.Errorf("message %s not found in the first %d messages", id, max)
+}
+
+// editedMessage checks the changed payload the way push does and sends it
+// inline, a claim check is not needed once edited
+func editedMessage(e entry, change func(old []byte) ([]byte, error)) (Message, error) {
+	var old bytes.Buffer
+	if err := json.Indent(&old, e.Payload, "", "  "); err != nil {
+		return Message{}, err
+	}
+	body, err := change(old.Bytes())
+	if err != nil {
+		return Message{}, err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, body); err != nil {
+		return Message{}, fmt.Errorf("edited payload: %w", err)
+	}
+	if err := payload.Validate(compact.Bytes()); err != nil {
+		return Message{}, err
+	}
+	if compact.Len() > payload.MaxMessageSize {
+		return Message{}, fmt.Errorf("edited payload is %d bytes, more than SQS takes", compact.Len())
+	}
+	m := copyMessage(e.msg)
+	m.Body = compact.String()
+	for key, val := range payload.Attributes(compact.Bytes()) {
+		m.Attributes[key] = val
+	}
+	if m.GroupID != "" {
+		// A new deduplication ID, else FIFO queues may drop the edited message
+		sum := sha256.Sum256(compact.Bytes())
+		m.DeduplicationID = hex.EncodeToString(sum[:])
+	}
+	return m, nil
+}
+
+// redrive moves the messages of dlq that f selects to target, without their
+// dead letter reason, and returns how many it moved
+func redrive(ctx context.Context, dlq, target Queue, f filter, max int, w io.Writer) (moved int, err error) {
+	entries, err := scan(ctx, dlq, max)
+	var keep []entry
+	defer func() { release(ctx, dlq, keep) }()
+	if err != nil {
+		keep = entries
+		return 0, err
+	}
+	for i, e := range entries {
+		if !f.matches(e) {
+			keep = append(keep, e)
+			continue
+		}
+		m := copyMessage(e.msg)
+		delete(m.Attributes, payload.AttrDeadLetterReason)
+		delete(m.Attributes, payload.AttrReceiveCount)
+		if err := target.Send(ctx, m); err != nil {
+			keep = append(keep, entries[i:]...)
+			return moved, fmt.Errorf("redrive %s: %w", e.ID, err)
+		}
+		if err := dlq.Delete(ctx, e.msg); err != nil {
+			// The message is on both queues now, see the idempotency table
+			log.WithError(err).WithField("id", e.ID).Warn("redriven but not deleted from the DLQ")
+		}
+		moved++
+		fmt.Fprintf(w, "redriven %s\n", e.ID)
+	}
+	return moved, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+const (
+	createUnit = `{"actionType":"CREATE_UNIT","mefeAPIRequestId":"r1","unitCreationRequestId":1}`
+	newMessage = `{"notification_type":"case_new_message","notification_id":"7","unit_id":1,"case_id":2,"created_by_user_id":"u"}`
+)
+
+// deadLettered fills a queue the way process leaves the DLQ
+func deadLettered(t *testing.T) *memoryQueue {
+	t.Helper()
+	q := newMemoryQueue()
+	ctx := context.Background()
+	for _, m := range []Message{
+		{Body: createUnit, Attributes: map[string]string{"actionType": "CREATE_UNIT", payload.AttrDeadLetterReason: "permanent: MEFE said no", payload.AttrReceiveCount: "4"}},
+		{Body: newMessage, Attributes: map[string]string{"notification_type": "case_new_message"}},
+		{Body: `not JSON`, Attributes: map[string]string{payload.AttrDeadLetterReason: "invalid: not JSON"}},
+	} {
+		if err := q.Send(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return q
+}
+
+func ids(q *memoryQueue) (out []string) {
+	for _, m := range q.Messages() {
+		out = append(out, m.ID)
+	}
+	return out
+}
+
+func Test_list(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter filter
+		want   []string
+	}{
+		{"all", filter{}, []string{"m001", "m002", "m003"}},
+		{"ids", filter{IDs: []string{"m003", "m001"}}, []string{"m001", "m003"}},
+		{"attribute", filter{Match: map[string]string{"actionType": "CREATE_UNIT"}}, []string{"m001"}},
+		{"payload field", filter{Match: map[string]string{"case_id": "2"}}, []string{"m002"}},
+		{"reason", filter{Reason: "invalid"}, []string{"m003"}},
+		{"nothing", filter{Match: map[string]string{"actionType": "EDIT_UNIT"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := deadLettered(t)
+			var out bytes.Buffer
+			if err := list(context.Background(), q, tt.filter, 100, &out); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			dec := json.NewDecoder(&out)
+			for dec.More() {
+				var e entry
+				if err := dec.Decode(&e); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, e.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("listed %v, want %v", got, tt.want)
+			}
+			// Listing leaves every message on the queue, visible
+			again, _ := q.Receive(context.Background(), 10)
+			if len(again) != 3 {
+				t.Errorf("%d messages visible after list, want 3", len(again))
+			}
+		})
+	}
+}
+
+func Test_listEntry(t *testing.T) {
+	q := deadLettered(t)
+	var out bytes.Buffer
+	if err := list(context.Background(), q, filter{IDs: []string{"m001"}}, 100, &out); err != nil {
+		t.Fatal(err)
+	}
+	var e entry
+	if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.LastError != "permanent: MEFE said no" || e.ReceiveCount != 4 || string(e.Payload) != createUnit {
+		t.Errorf("entry = %+v", e)
+	}
+}
+
+func Test_inspect(t *testing.T) {
+	q := deadLettered(t)
+	var out bytes.Buffer
+	if err := inspect(context.Background(), q, "m003", 100, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"rawBody": "not JSON"`) {
+		t.Errorf("inspect = %s", out.String())
+	}
+	if err := inspect(context.Background(), q, "m009", 100, &out); err == nil {
+		t.Error("inspected a missing message")
+	}
+}
+
+func Test_edit(t *testing.T) {
+	fix := func(old []byte) ([]byte, error) {
+		return bytes.Replace(old, []byte(`"r1"`), []byte(`"r2"`), 1), nil
+	}
+	t.Run("back to the DLQ", func(t *testing.T) {
+		q := deadLettered(t)
+		m, err := edit(context.Background(), q, nil, "m001", 100, fix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(m.Body, `"mefeAPIRequestId":"r2"`) {
+			t.Errorf("body = %s", m.Body)
+		}
+		if got := strings.Join(ids(q), ","); got != "m002,m003,m004" {
+			t.Errorf("DLQ holds %s, want the edited m001 as m004", got)
+		}
+		if q.Messages()[2].Attributes[payload.AttrDeadLetterReason] == "" {
+			t.Error("edited message lost its dead letter reason")
+		}
+	})
+	t.Run("redrive", func(t *testing.T) {
+		q, main := deadLettered(t), newMemoryQueue()
+		if _, err := edit(context.Background(), q, main, "m001", 100, fix); err != nil {
+			t.Fatal(err)
+		}
+		sent := main.Messages()
+		if len(sent) != 1 || sent[0].Attributes[payload.AttrDeadLetterReason] != "" {
+			t.Errorf("redriven %+v", sent)
+		}
+		if len(q.Messages()) != 2 {
+			t.Errorf("m001 is still in the DLQ")
+		}
+	})
+	t.Run("invalid edit", func(t *testing.T) {
+		q := deadLettered(t)
+		_, err := edit(context.Background(), q, nil, "m001", 100, func([]byte) ([]byte, error) {
+			return []byte(`{"actionType":"CREATE_UNIT"}`), nil
+		})
+		if err == nil {
+			t.Fatal("accepted a payload without mefeAPIRequestId")
+		}
+		if got := strings.Join(ids(q), ","); got != "m001,m002,m003" {
+			t.Errorf("DLQ holds %s after a failed edit", got)
+		}
+		if again, _ := q.Receive(context.Background(), 10); len(again) != 3 {
+			t.Errorf("%d messages visible after a failed edit, want 3", len(again))
+		}
+	})
+}
+
+func Test_redrive(t *testing.T) {
+	q, main := deadLettered(t), newMemoryQueue()
+	var out bytes.Buffer
+	moved, err := redrive(context.Background(), q, main, filter{Reason: "permanent"}, 100, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 || out.String() != "redriven m001\n" {
+		t.Errorf("moved %d: %s", moved, out.String())
+	}
+	sent := main.Messages()
+	if len(sent) != 1 || sent[0].Body != createUnit || sent[0].Attributes["actionType"] != "CREATE_UNIT" {
+		t.Errorf("main queue holds %+v", sent)
+	}
+	if _, ok := sent[0].Attributes[payload.AttrDeadLetterReason]; ok {
+		t.Error("redriven message kept its dead letter reason")
+	}
+	if _, ok := sent[0].Attributes[payload.AttrReceiveCount]; ok {
+		t.Error("redriven message kept its receive count")
+	}
+	if got := strings.Join(ids(q), ","); got != "m002,m003" {
+		t.Errorf("DLQ holds %s", got)
+	}
+	if again, _ := q.Receive(context.Background(), 10); len(again) != 2 {
+		t.Errorf("%d messages visible after redrive, want 2", len(again))
+	}
+}
+
+func Test_sendMessageInput(t *testing.T) {
+	m := Message{
+		Body:       createUnit,
+		Attributes: map[string]string{payload.AttrSchemaVersion: "1", payload.AttrMEFERequestID: "4300", payload.AttrActionType: "CREATE_UNIT"},
+		DataTypes:  map[string]string{payload.AttrSchemaVersion: "Number", payload.AttrMEFERequestID: "String"},
+	}
+	attrs := sendMessageInput("https://sqs/dlq", m).MessageAttributes
+	for key, want := range map[string]string{payload.AttrSchemaVersion: "Number", payload.AttrMEFERequestID: "String", payload.AttrActionType: "String"} {
+		if got := *attrs[key].DataType; got != want {
+			t.Errorf("sendMessageInput() %s DataType = %s, want %s", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// SchemaVersion is bumped whenever the message body or attributes change shape
+const SchemaVersion = "1"
+
+// Message attributes push sets so consumers can route without parsing the body
+const (
+	AttrActionType       = "actionType"
+	AttrNotificationType = "notification_type"
+	AttrSourceTable      = "bz_source_table"
+	AttrMEFERequestID    = "mefeAPIRequestId"
+	AttrStage            = "stage"
+	AttrSchemaVersion    = "schemaVersion"
+)
+
+// Attributes returns the routing metadata found in a payload, numbers included as strings
+func Attributes(evt json.RawMessage) map[string]string {
+	d := json.NewDecoder(bytes.NewReader(evt))
+	d.UseNumber()
+	var rec map[string]interface{}
+	if d.Decode(&rec) != nil {
+		return nil
+	}
+	attrs := map[string]string{}
+	for _, key := range []string{AttrActionType, AttrNotificationType, AttrSourceTable, AttrMEFERequestID} {
+		switch v := rec[key].(type) {
+		case string:
+			if v != "" {
+				attrs[key] = v
+			}
+		case json.Number:
+			attrs[key] = v.String()
+		}
+	}
+	return attrs
+}
+
+// Attributes push adds for SNS filter policies
+const (
+	AttrMessageType = "messageType"
+	AttrUnitID      = "unit_id"
+	AttrCaseID      = "case_id"
+)
+
+// Set by process on the messages it dead-letters: why, and how many times
+// the message was received before
+const (
+	AttrDeadLetterReason = "deadLetterReason"
+	AttrReceiveCount     = "receiveCount"
+)
+
+// AttrDryRun set to true makes process log the message's MEFE request and
+// reply call instead of making them
+const AttrDryRun = "dryRun"
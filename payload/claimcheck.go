@@ -0,0 +1,82 @@
+package payload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// MaxMessageSize is the largest SQS message, body and attributes together
+const MaxMessageSize = 256 * 1024
+
+// BlobStore keeps the payloads too large to enqueue
+type BlobStore interface {
+	Put(ctx context.Context, key string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ClaimCheck is enqueued in place of an oversized payload
+type ClaimCheck struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+	// IdempotencyKey lets process skip a duplicate before fetching the payload
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+type claimCheckBody struct {
+	ClaimCheck *ClaimCheck `json:"claimCheck"`
+}
+
+// BlobKey addresses a payload by its content, so a redelivered payload reuses its blob
+func BlobKey(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "payloads/" + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// NewClaimCheck stores body and returns the pointer to enqueue instead
+func NewClaimCheck(ctx context.Context, store BlobStore, body []byte) (json.RawMessage, error) {
+	key := BlobKey(body)
+	if err := store.Put(ctx, key, body); err != nil {
+		return nil, err
+	}
+	return json.Marshal(claimCheckBody{&ClaimCheck{Key: key, Size: len(body), IdempotencyKey: IdempotencyKey(body)}})
+}
+
+// ParseClaimCheck returns the pointer if body is one, else nil
+func ParseClaimCheck(body []byte) *ClaimCheck {
+	var cc claimCheckBody
+	if json.Unmarshal(body, &cc) != nil || cc.ClaimCheck == nil || cc.ClaimCheck.Key == "" {
+		return nil
+	}
+	return cc.ClaimCheck
+}
+
+// DirStore is a BlobStore on the local filesystem, standing in for S3 in tests and local dev
+type DirStore struct {
+	Dir string
+}
+
+func (s DirStore) Put(ctx context.Context, key string, body []byte) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, body, 0644)
+}
+
+func (s DirStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(key)))
+}
+
+func (s DirStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package payload
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestClaimCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "claimcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := DirStore{Dir: dir}
+	ctx := context.Background()
+
+	body := []byte(`{"notification_type": "case_new_message", "notification_id": 4300, "message_truncated": "Its cute >_<"}`)
+	pointer, err := NewClaimCheck(ctx, store, body)
+	if err != nil {
+		t.Fatalf("NewClaimCheck() error = %v", err)
+	}
+	cc := ParseClaimCheck(pointer)
+	if cc == nil || cc.Key != BlobKey(body) || cc.Size != len(body) || cc.IdempotencyKey != "notification_id:4300" {
+		t.Fatalf("ParseClaimCheck() = %+v", cc)
+	}
+	got, err := store.Get(ctx, cc.Key)
+	if err != nil || string(got) != string(body) {
+		t.Errorf("Get() = %s, %v", got, err)
+	}
+	if err := store.Delete(ctx, cc.Key); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+	if _, err := store.Get(ctx, cc.Key); err == nil {
+		t.Error("Get() after Delete() wants error")
+	}
+	if ParseClaimCheck(body) != nil {
+		t.Error("ParseClaimCheck() of a payload wants nil")
+	}
+}
@@ -0,0 +1,157 @@
+package payload
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ActionDefinition declares an actionType, so a new one like DELETE_UNIT
+// needs a config change instead of a Go release
+type ActionDefinition struct {
+	ActionType string `json:"actionType"`
+	// RequestIDField is the request ID the payload must carry
+	RequestIDField string `json:"requestIdField"`
+	// Endpoint is the MEFE API path, /api/process-api-payload when empty
+	Endpoint string `json:"endpoint,omitempty"`
+	// IDField is the response field that becomes the ID, "id" when empty
+	IDField string `json:"idField,omitempty"`
+	// Procedure is the reply stored procedure
+	Procedure string `json:"procedure"`
+	// Params map the procedure's session variables, in order
+	Params []ReplyParam `json:"params"`
+	// Retry overrides the default retry of transient MEFE failures
+	Retry RetryPolicy `json:"retry,omitempty"`
+}
+
+// RetryPolicy bounds the retries of transient MEFE failures within an
+// invocation, with exponential backoff and jitter. Zero fields keep the default.
+type RetryPolicy struct {
+	MaxAttempts int      `json:"maxAttempts,omitempty"`
+	BaseDelay   Duration `json:"baseDelay,omitempty"`
+	MaxDelay    Duration `json:"maxDelay,omitempty"`
+	// Budget is the total time retries may take
+	Budget Duration `json:"budget,omitempty"`
+}
+
+// Or fills the zero fields of p from def
+func (p RetryPolicy) Or(def RetryPolicy) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = def.MaxAttempts
+	}
+	if p.BaseDelay.Duration == 0 {
+		p.BaseDelay = def.BaseDelay
+	}
+	if p.MaxDelay.Duration == 0 {
+		p.MaxDelay = def.MaxDelay
+	}
+	if p.Budget.Duration == 0 {
+		p.Budget = def.Budget
+	}
+	return p
+}
+
+// Duration is a time.Duration written like "200ms" or "5s" in JSON
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a time.ParseDuration string
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// ReplyParam binds session variable @Var to a value From
+//
+//	request.<field>      a payload field
+//	reply.id             the response IDField
+//	reply.timestamp      the response timestamp as a SQL datetime
+//	reply.isCreatedByMe  1 when MEFE answered 201 Created
+//	reply.errorMessage   the MEFE error, empty on success
+//	reply.<field>        any other response field
+type ReplyParam struct {
+	Var  string `json:"var"`
+	From string `json:"from"`
+}
+
+// sqlIdentifier is what a procedure or session variable name may be, they
+// are written into the SQL process runs
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Check rejects a definition that could never be processed
+func (d ActionDefinition) Check() error {
+	switch {
+	case d.ActionType == "":
+		return fmt.Errorf("action definition: missing actionType")
+	case d.RequestIDField == "":
+		return fmt.Errorf("action definition %s: missing requestIdField", d.ActionType)
+	case d.Procedure == "":
+		return fmt.Errorf("action definition %s: missing procedure", d.ActionType)
+	case !sqlIdentifier.MatchString(d.Procedure):
+		return fmt.Errorf("action definition %s: procedure %q is not an SQL identifier", d.ActionType, d.Procedure)
+	}
+	for _, p := range d.Params {
+		if p.Var == "" {
+			return fmt.Errorf("action definition %s: param without var", d.ActionType)
+		}
+		if !sqlIdentifier.MatchString(p.Var) {
+			return fmt.Errorf("action definition %s: var %q is not an SQL identifier", d.ActionType, p.Var)
+		}
+		if !strings.HasPrefix(p.From, "request.") && !strings.HasPrefix(p.From, "reply.") {
+			return fmt.Errorf("action definition %s: param %s from %q is neither request. nor reply.", d.ActionType, p.Var, p.From)
+		}
+	}
+	if d.Retry.MaxAttempts < 0 || d.Retry.BaseDelay.Duration < 0 || d.Retry.MaxDelay.Duration < 0 || d.Retry.Budget.Duration < 0 {
+		return fmt.Errorf("action definition %s: negative retry", d.ActionType)
+	}
+	return nil
+}
+
+// LoadActionDefinitions reads the ACTION_TYPES setting, a JSON list of
+// ActionDefinition inline or in a file. An empty setting defines nothing.
+func LoadActionDefinitions(setting string) (defs []ActionDefinition, err error) {
+	if setting == "" {
+		return nil, nil
+	}
+	if err := LoadSetting(setting, &defs); err != nil {
+		return nil, err
+	}
+	for _, d := range defs {
+		if err := d.Check(); err != nil {
+			return nil, err
+		}
+	}
+	return defs, nil
+}
+
+// Define makes the definitions' action types known to Validate
+func Define(defs []ActionDefinition) {
+	for _, d := range defs {
+		RequiredID[d.ActionType] = d.RequestIDField
+	}
+}
+
+// LoadSetting unmarshals a setting that is either inline JSON or a path to a JSON file
+func LoadSetting(setting string, v interface{}) (err error) {
+	data := []byte(setting)
+	if s := strings.TrimSpace(setting); !strings.HasPrefix(s, "{") && !strings.HasPrefix(s, "[") {
+		data, err = ioutil.ReadFile(setting)
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,38 @@
+package payload
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLoadActionDefinitions(t *testing.T) {
+	defs, err := LoadActionDefinitions(`[{"actionType": "DELETE_UNIT", "requestIdField": "deleteUnitRequestId", "procedure": "ut_delete_unit_mefe_api_reply",
+		"params": [{"var": "delete_unit_request_id", "from": "request.deleteUnitRequestId"}, {"var": "mefe_api_error_message", "from": "reply.errorMessage"}]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := json.RawMessage(`{"actionType": "DELETE_UNIT", "mefeAPIRequestId": "e7bb7494", "deleteUnitRequestId": 12}`)
+	if err := Validate(evt); err == nil {
+		t.Fatal("Validate() accepted DELETE_UNIT before Define")
+	}
+	Define(defs)
+	defer delete(RequiredID, "DELETE_UNIT")
+	if err := Validate(evt); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := Validate(json.RawMessage(`{"actionType": "DELETE_UNIT", "mefeAPIRequestId": "e7bb7494"}`)); err == nil {
+		t.Error("Validate() accepted DELETE_UNIT without deleteUnitRequestId")
+	}
+
+	for _, setting := range []string{
+		`[{"actionType": "DELETE_UNIT", "procedure": "ut_delete_unit_mefe_api_reply"}]`,
+		`[{"actionType": "DELETE_UNIT", "requestIdField": "deleteUnitRequestId", "procedure": "p", "params": [{"var": "v", "from": "body.id"}]}]`,
+		`[{"actionType": "DELETE_UNIT", "requestIdField": "deleteUnitRequestId", "procedure": "p; DROP TABLE units"}]`,
+		`[{"actionType": "DELETE_UNIT", "requestIdField": "deleteUnitRequestId", "procedure": "p", "params": [{"var": "v = 1, @w", "from": "request.id"}]}]`,
+		`testdata/missing.json`,
+	} {
+		if _, err := LoadActionDefinitions(setting); err == nil {
+			t.Errorf("LoadActionDefinitions(%s) accepted", setting)
+		}
+	}
+}
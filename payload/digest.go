@@ -0,0 +1,204 @@
+package payload
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DecodingSpec lists the base64 encoded fields per actionType or
+// notification_type. Fields under "*" apply to every payload.
+//
+// A field is a dot separated path, where "*" matches every key of an object
+// or every element of an array and a number matches an array index, e.g.
+// "roleVisibility.*" or "invitees.0.name".
+type DecodingSpec map[string][]string
+
+// DefaultDecodingSpec is the spec unless DECODING_SPEC says otherwise
+var DefaultDecodingSpec = DecodingSpec{
+	"*": {"firstName", "lastName", "phoneNumber", "name", "moreInfo", "streetAddress", "city", "state"},
+}
+
+// LoadDecodingSpec reads the DECODING_SPEC setting. An empty setting gives the DefaultDecodingSpec.
+func LoadDecodingSpec(setting string) (spec DecodingSpec, err error) {
+	if setting == "" {
+		return DefaultDecodingSpec, nil
+	}
+	err = LoadSetting(setting, &spec)
+	return spec, err
+}
+
+// Fields returns the encoded field paths for a payload
+func (spec DecodingSpec) Fields(rec map[string]interface{}) (paths []string) {
+	paths = append(paths, spec["*"]...)
+	for _, key := range []string{"actionType", "notification_type"} {
+		if t, ok := rec[key].(string); ok && t != "" {
+			paths = append(paths, spec[t]...)
+		}
+	}
+	return paths
+}
+
+// Digest decodes the base64 encoded fields of a payload, the way push does
+// before enqueueing it, and returns which ones it decoded. strict rejects
+// unmarked fields that look base64 encoded instead of guessing.
+func Digest(evt json.RawMessage, spec DecodingSpec, strict bool) (out json.RawMessage, decoded []string, err error) {
+	var input interface{}
+	err = json.Unmarshal(evt, &input)
+	if err != nil {
+		return out, decoded, err
+	}
+	if rec, ok := input.(map[string]interface{}); ok {
+		d := newDecoder(strict)
+		err = d.decode(rec, spec.Fields(rec))
+		if err != nil {
+			return out, d.decoded, err
+		}
+		out, err = json.Marshal(rec)
+		return out, d.decoded, err
+	}
+	return
+}
+
+// Stored procedures mark encoded values explicitly, either with a "b64:"
+// prefix on the value or by listing the field paths in an "_encoded" array
+const (
+	markerPrefix = "b64:"
+	markerField  = "_encoded"
+)
+
+// decoder decodes a payload in place and records which fields it decoded
+type decoder struct {
+	// strict rejects unmarked fields that look base64 encoded instead of guessing
+	strict  bool
+	decoded []string
+	done    map[string]bool
+	err     error
+}
+
+func newDecoder(strict bool) *decoder {
+	return &decoder{strict: strict, done: map[string]bool{}}
+}
+
+// decode runs the explicit markers first, then the spec fields
+func (d *decoder) decode(rec map[string]interface{}, paths []string) error {
+	if list, ok := rec[markerField].([]interface{}); ok {
+		delete(rec, markerField)
+		for _, p := range list {
+			if p, ok := p.(string); ok {
+				d.walk(rec, strings.Split(p, "."), "", true)
+			}
+		}
+	}
+	d.markers(rec, "")
+	for _, p := range paths {
+		d.walk(rec, strings.Split(p, "."), "", false)
+	}
+	return d.err
+}
+
+// markers decodes every string value carrying the markerPrefix
+func (d *decoder) markers(node interface{}, at string) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, val := range n {
+			if s, ok := val.(string); ok && strings.HasPrefix(s, markerPrefix) {
+				n[key] = d.value(join(at, key), s, true)
+			} else {
+				d.markers(val, join(at, key))
+			}
+		}
+	case []interface{}:
+		for i, val := range n {
+			if s, ok := val.(string); ok && strings.HasPrefix(s, markerPrefix) {
+				n[i] = d.value(join(at, strconv.Itoa(i)), s, true)
+			} else {
+				d.markers(val, join(at, strconv.Itoa(i)))
+			}
+		}
+	}
+}
+
+// walk decodes the string values found at path, where "*" matches every key
+// or element and a number matches an array index
+func (d *decoder) walk(node interface{}, path []string, at string, explicit bool) {
+	if len(path) == 0 {
+		return
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, val := range n {
+			if path[0] != "*" && path[0] != key {
+				continue
+			}
+			if len(path) == 1 {
+				n[key] = d.value(join(at, key), val, explicit)
+			} else {
+				d.walk(val, path[1:], join(at, key), explicit)
+			}
+		}
+	case []interface{}:
+		for i, val := range n {
+			index := strconv.Itoa(i)
+			if path[0] != "*" && path[0] != index {
+				continue
+			}
+			if len(path) == 1 {
+				n[i] = d.value(join(at, index), val, explicit)
+			} else {
+				d.walk(val, path[1:], join(at, index), explicit)
+			}
+		}
+	}
+}
+
+func (d *decoder) value(at string, val interface{}, explicit bool) interface{} {
+	s, ok := val.(string)
+	if !ok || s == "" || d.done[at] {
+		return val
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, markerPrefix))
+	switch {
+	case explicit && err != nil:
+		d.fail(&DecodeError{Field: at, Reason: fmt.Sprintf("marked encoded but is not base64: %v", err)})
+		return val
+	case explicit && !utf8.Valid(data):
+		d.fail(&DecodeError{Field: at, Reason: "marked encoded but does not decode to UTF-8"})
+		return val
+	case err != nil || !utf8.Valid(data):
+		// Not base64, a plain value
+		return val
+	case !explicit && d.strict:
+		d.fail(&DecodeError{Field: at, Reason: "ambiguous: looks base64 encoded but is not marked"})
+		return val
+	}
+	d.done[at] = true
+	d.decoded = append(d.decoded, at)
+	return string(data)
+}
+
+// DecodeError is a field that could not be decoded
+type DecodeError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e *DecodeError) Error() string {
+	return e.Field + " is " + e.Reason
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func join(at, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
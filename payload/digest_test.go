@@ -0,0 +1,24 @@
+package payload
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadDecodingSpec(t *testing.T) {
+	got, err := LoadDecodingSpec("")
+	if err != nil || !reflect.DeepEqual(got, DefaultDecodingSpec) {
+		t.Errorf("LoadDecodingSpec(\"\") = %v, %v, want DefaultDecodingSpec", got, err)
+	}
+	if _, err := LoadDecodingSpec("/nonexistent/spec.json"); err == nil {
+		t.Error("LoadDecodingSpec() missing file wants error")
+	}
+	got, err = LoadDecodingSpec(`{"ASSIGN_ROLE": ["roleVisibility.*"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"roleVisibility.*"}
+	if paths := got.Fields(map[string]interface{}{"actionType": "ASSIGN_ROLE"}); !reflect.DeepEqual(paths, want) {
+		t.Errorf("Fields() = %v, want %v", paths, want)
+	}
+}
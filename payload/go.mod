@@ -0,0 +1,3 @@
+module github.com/unee-t/lambda2sqs/payload
+
+go 1.12
@@ -0,0 +1,205 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ID is a Bugzilla or enterprise DB ID, which the DB emits as a string or a number
+type ID string
+
+// UnmarshalJSON accepts "2203", 2203 and null
+func (id *ID) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*id = ""
+	case string:
+		*id = ID(strings.TrimSpace(v))
+	case json.Number:
+		*id = ID(v.String())
+	default:
+		return fmt.Errorf("ID %s is neither a string nor a number", b)
+	}
+	return nil
+}
+
+// Empty tells a missing ID, the DB uses "0" for none
+func (id ID) Empty() bool {
+	return id == "" || id == "0"
+}
+
+// IDList is a list of IDs, which the DB emits as a comma separated string
+type IDList []ID
+
+// UnmarshalJSON accepts "6017,6018", 6017 and ["6017", 6018], dropping "0"
+func (l *IDList) UnmarshalJSON(b []byte) error {
+	var ids []ID
+	if err := json.Unmarshal(b, &ids); err != nil {
+		var s ID
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		for _, part := range strings.Split(string(s), ",") {
+			ids = append(ids, ID(strings.TrimSpace(part)))
+		}
+	}
+	*l = nil
+	for _, id := range ids {
+		if !id.Empty() {
+			*l = append(*l, id)
+		}
+	}
+	return nil
+}
+
+// Notification holds the fields every case notification from Bugzilla carries
+type Notification struct {
+	Type                  string `json:"notification_type"`
+	SourceTable           string `json:"bz_source_table"`
+	ID                    string `json:"notification_id"`
+	CreatedDatetime       string `json:"created_datetime"`
+	UnitID                ID     `json:"unit_id"`
+	CaseID                ID     `json:"case_id"`
+	CaseTitle             string `json:"case_title"`
+	CaseReporterUserID    ID     `json:"case_reporter_user_id"`
+	OldCaseAssigneeUserID ID     `json:"old_case_assignee_user_id"`
+	NewCaseAssigneeUserID ID     `json:"new_case_assignee_user_id"`
+	CurrentListOfInvitees IDList `json:"current_list_of_invitees"`
+	CurrentStatus         string `json:"current_status"`
+	CurrentResolution     string `json:"current_resolution"`
+	CurrentSeverity       string `json:"current_severity"`
+}
+
+// Header returns the common fields
+func (n *Notification) Header() *Notification { return n }
+
+// Validate checks the fields MEFE needs of every notification
+func (n *Notification) Validate() error {
+	return n.require(map[string]bool{
+		"notification_id": n.ID == "",
+		"unit_id":         n.UnitID.Empty(),
+		"case_id":         n.CaseID.Empty(),
+	})
+}
+
+// require returns a *ValidationError for the first missing field, in name order
+func (n *Notification) require(missing map[string]bool) error {
+	var first string
+	for field, m := range missing {
+		if m && (first == "" || field < first) {
+			first = field
+		}
+	}
+	if first == "" {
+		return nil
+	}
+	return &ValidationError{NotificationType: n.Type, Field: first, Reason: "missing"}
+}
+
+// CaseNewMessage is a new message on a case
+type CaseNewMessage struct {
+	Notification
+	CreatedByUserID  ID     `json:"created_by_user_id"`
+	MessageTruncated string `json:"message_truncated"`
+}
+
+// Validate checks the message has an author
+func (n *CaseNewMessage) Validate() error {
+	if err := n.Notification.Validate(); err != nil {
+		return err
+	}
+	return n.require(map[string]bool{"created_by_user_id": n.CreatedByUserID.Empty()})
+}
+
+// CaseUpdated is a change to a case field
+type CaseUpdated struct {
+	Notification
+	UserID     ID     `json:"user_id"`
+	UpdateWhat string `json:"update_what"`
+	OldValue   string `json:"old_value"`
+	NewValue   string `json:"new_value"`
+}
+
+// Validate checks who changed what
+func (n *CaseUpdated) Validate() error {
+	if err := n.Notification.Validate(); err != nil {
+		return err
+	}
+	return n.require(map[string]bool{
+		"user_id":     n.UserID.Empty(),
+		"update_what": n.UpdateWhat == "",
+	})
+}
+
+// CaseUserInvited is a user invited to a case
+type CaseUserInvited struct {
+	Notification
+	InviteeUserID ID `json:"invitee_user_id"`
+}
+
+// Validate checks who was invited
+func (n *CaseUserInvited) Validate() error {
+	if err := n.Notification.Validate(); err != nil {
+		return err
+	}
+	return n.require(map[string]bool{"invitee_user_id": n.InviteeUserID.Empty()})
+}
+
+// CaseAssigneeUpdated is a case assigned to someone else
+type CaseAssigneeUpdated struct {
+	Notification
+	InvitorUserID ID `json:"invitor_user_id"`
+}
+
+// Validate checks the case has a new assignee
+func (n *CaseAssigneeUpdated) Validate() error {
+	if err := n.Notification.Validate(); err != nil {
+		return err
+	}
+	return n.require(map[string]bool{"new_case_assignee_user_id": n.NewCaseAssigneeUserID.Empty()})
+}
+
+// CaseNotification is any of the typed notifications
+type CaseNotification interface {
+	Header() *Notification
+	Validate() error
+}
+
+// notificationTypes makes an empty CaseNotification per notification_type
+var notificationTypes = map[string]func() CaseNotification{
+	"case_new_message":      func() CaseNotification { return new(CaseNewMessage) },
+	"case_updated":          func() CaseNotification { return new(CaseUpdated) },
+	"case_user_invited":     func() CaseNotification { return new(CaseUserInvited) },
+	"case_assignee_updated": func() CaseNotification { return new(CaseAssigneeUpdated) },
+}
+
+// ParseNotification unmarshals and validates a notification payload.
+// Unknown notification types are a *ValidationError.
+func ParseNotification(evt json.RawMessage) (CaseNotification, error) {
+	var probe struct {
+		Type string `json:"notification_type"`
+	}
+	if err := json.Unmarshal(evt, &probe); err != nil {
+		return nil, &ValidationError{Reason: fmt.Sprintf("unable to unmarshall payload: %v", err)}
+	}
+	if probe.Type == "" {
+		return nil, &ValidationError{Field: AttrNotificationType, Reason: "missing"}
+	}
+	newNotification, ok := notificationTypes[probe.Type]
+	if !ok {
+		return nil, &ValidationError{NotificationType: probe.Type, Reason: "unknown type"}
+	}
+	n := newNotification()
+	if err := json.Unmarshal(evt, n); err != nil {
+		return nil, &ValidationError{NotificationType: probe.Type, Reason: fmt.Sprintf("unable to unmarshall payload: %v", err)}
+	}
+	return n, n.Validate()
+}
@@ -0,0 +1,76 @@
+package payload
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseNotificationEvents(t *testing.T) {
+	for _, name := range []string{"case_new_message", "case_updated", "case_user_invited", "case_assignee_updated"} {
+		t.Run(name, func(t *testing.T) {
+			evt, err := ioutil.ReadFile(filepath.Join("..", "tests", "events", name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := ParseNotification(evt)
+			if err != nil {
+				t.Fatalf("ParseNotification() error = %v", err)
+			}
+			if n.Header().Type != name {
+				t.Errorf("ParseNotification() type = %s", n.Header().Type)
+			}
+		})
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	n, err := ParseNotification(json.RawMessage(`{"notification_type": "case_user_invited", "notification_id": "ut_notification_case_invited-87",
+		"unit_id": 2203, "case_id": "3293", "invitee_user_id": 6017, "current_list_of_invitees": "6017, 6018,0"}`))
+	if err != nil {
+		t.Fatalf("ParseNotification() error = %v", err)
+	}
+	invited, ok := n.(*CaseUserInvited)
+	if !ok {
+		t.Fatalf("ParseNotification() = %T, want *CaseUserInvited", n)
+	}
+	if invited.UnitID != "2203" || invited.InviteeUserID != "6017" {
+		t.Errorf("ParseNotification() IDs = %q %q", invited.UnitID, invited.InviteeUserID)
+	}
+	if want := (IDList{"6017", "6018"}); !reflect.DeepEqual(invited.CurrentListOfInvitees, want) {
+		t.Errorf("ParseNotification() invitees = %v, want %v", invited.CurrentListOfInvitees, want)
+	}
+
+	tests := []struct {
+		name      string
+		evt       string
+		wantField string
+	}{
+		{"unknown type", `{"notification_type": "case_deleted", "notification_id": "ut_notification_case_deleted-1"}`, ""},
+		{"no type", `{"notification_id": "ut_notification_case_deleted-1"}`, "notification_type"},
+		{"no case", `{"notification_type": "case_updated", "notification_id": "ut_notification_case_updated-494", "unit_id": "2203", "case_id": "0"}`, "case_id"},
+		{"no update_what", `{"notification_type": "case_updated", "notification_id": "ut_notification_case_updated-494", "unit_id": "2203", "case_id": "3293", "user_id": "6016"}`, "update_what"},
+		{"object ID", `{"notification_type": "case_updated", "unit_id": {"id": 1}}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseNotification(json.RawMessage(tt.evt))
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("ParseNotification() error = %v, want *ValidationError", err)
+			}
+			if verr.Field != tt.wantField {
+				t.Errorf("ParseNotification() field = %q, want %q", verr.Field, tt.wantField)
+			}
+			if verr.ActionType != "" {
+				t.Errorf("ParseNotification() actionType = %q, want none", verr.ActionType)
+			}
+		})
+	}
+	_, err = ParseNotification(json.RawMessage(tests[0].evt))
+	if verr, ok := err.(*ValidationError); !ok || verr.NotificationType != "case_deleted" || verr.Error() != "unknown type: case_deleted" {
+		t.Errorf("ParseNotification() error = %#v, want unknown type case_deleted", err)
+	}
+}
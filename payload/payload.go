@@ -0,0 +1,128 @@
+// Package payload holds the payload rules shared by push and process
+package payload
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ActionType is a MEFE API request from the enterprise DB https://github.com/unee-t/lambda2sns/issues/9
+type ActionType struct {
+	UnitCreationRequestID       int    `json:"unitCreationRequestId,omitempty"`
+	UserCreationRequestID       int    `json:"userCreationRequestId,omitempty"`
+	IDmapUserUnitPermissions    int    `json:"idMapUserUnitPermission,omitempty"`
+	MEFERequestID               ID     `json:"mefeAPIRequestId,omitempty"`
+	UpdateUserRequestID         int    `json:"updateUserRequestId,omitempty"`
+	UpdateUnitRequestID         int    `json:"updateUnitRequestId,omitempty"`
+	RemoveUserFromUnitRequestID int    `json:"removeUserFromUnitRequestId,omitempty"`
+	Type                        string `json:"actionType"`
+}
+
+// RequiredID names the request ID field each action type must carry
+var RequiredID = map[string]string{
+	"CREATE_UNIT":   "unitCreationRequestId",
+	"EDIT_USER":     "updateUserRequestId",
+	"EDIT_UNIT":     "updateUnitRequestId",
+	"CREATE_USER":   "userCreationRequestId",
+	"ASSIGN_ROLE":   "idMapUserUnitPermission",
+	"DEASSIGN_ROLE": "removeUserFromUnitRequestId",
+}
+
+// ValidationError describes why a payload can never be processed
+type ValidationError struct {
+	ActionType       string `json:"actionType,omitempty"`
+	NotificationType string `json:"notificationType,omitempty"`
+	Field            string `json:"field,omitempty"`
+	Reason           string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s %s", e.Reason, e.Field)
+	}
+	if e.ActionType != "" {
+		return fmt.Sprintf("%s: %s", e.Reason, e.ActionType)
+	}
+	if e.NotificationType != "" {
+		return fmt.Sprintf("%s: %s", e.Reason, e.NotificationType)
+	}
+	return e.Reason
+}
+
+// IsActionType tells an actionType payload from a notification
+func IsActionType(evt json.RawMessage) bool {
+	var probe struct {
+		Type interface{} `json:"actionType"`
+	}
+	if json.Unmarshal(evt, &probe) != nil {
+		return false
+	}
+	_, ok := probe.Type.(string)
+	return ok
+}
+
+// ParseActionType unmarshals and validates an actionType payload
+func ParseActionType(evt json.RawMessage) (act ActionType, err error) {
+	if err := json.Unmarshal(evt, &act); err != nil {
+		return act, &ValidationError{Reason: fmt.Sprintf("unable to unmarshall payload: %v", err)}
+	}
+	if act.MEFERequestID.Empty() {
+		return act, &ValidationError{ActionType: act.Type, Field: "mefeAPIRequestId", Reason: "missing"}
+	}
+	field, ok := RequiredID[act.Type]
+	if !ok {
+		return act, &ValidationError{ActionType: act.Type, Reason: "unknown type"}
+	}
+	if RequestID(evt, field) == "" {
+		return act, &ValidationError{ActionType: act.Type, Field: field, Reason: "missing"}
+	}
+	return act, nil
+}
+
+// RequestID returns the request ID field of evt, empty when missing or 0
+func RequestID(evt json.RawMessage, field string) string {
+	var rec map[string]json.RawMessage
+	if json.Unmarshal(evt, &rec) != nil {
+		return ""
+	}
+	var id interface{}
+	if json.Unmarshal(rec[field], &id) != nil {
+		return ""
+	}
+	switch v := id.(type) {
+	case string:
+		return v
+	case float64:
+		if v != 0 {
+			return string(rec[field])
+		}
+	}
+	return ""
+}
+
+// IdempotencyKey names a payload by its mefeAPIRequestId, or notification_id
+// for a notification, empty when it has none
+func IdempotencyKey(evt json.RawMessage) string {
+	field := "notification_id"
+	if IsActionType(evt) {
+		field = "mefeAPIRequestId"
+	}
+	var rec map[string]json.RawMessage
+	if json.Unmarshal(evt, &rec) != nil || rec[field] == nil {
+		return ""
+	}
+	var id ID
+	if json.Unmarshal(rec[field], &id) != nil || id == "" {
+		return ""
+	}
+	return field + ":" + string(id)
+}
+
+// Validate checks an actionType payload, process checks notifications with ParseNotification
+func Validate(evt json.RawMessage) error {
+	if !IsActionType(evt) {
+		return nil
+	}
+	_, err := ParseActionType(evt)
+	return err
+}
@@ -0,0 +1,95 @@
+package payload
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		evt       string
+		wantField string
+		wantErr   bool
+	}{
+		{
+			name: "valid CREATE_UNIT",
+			evt:  `{"actionType": "CREATE_UNIT", "mefeAPIRequestId": "e7bb7494", "unitCreationRequestId": 4771}`,
+		},
+		{
+			name: "numeric mefeAPIRequestId",
+			evt:  `{"actionType": "CREATE_UNIT", "mefeAPIRequestId": 1, "unitCreationRequestId": 4771}`,
+		},
+		{
+			name:      "missing mefeAPIRequestId",
+			evt:       `{"actionType": "CREATE_UNIT", "unitCreationRequestId": 4771}`,
+			wantField: "mefeAPIRequestId",
+			wantErr:   true,
+		},
+		{
+			name:      "missing removeUserFromUnitRequestId",
+			evt:       `{"actionType": "DEASSIGN_ROLE", "mefeAPIRequestId": "e7bb7494", "unitCreationRequestId": 4771}`,
+			wantField: "removeUserFromUnitRequestId",
+			wantErr:   true,
+		},
+		{
+			name:    "unknown type",
+			evt:     `{"actionType": "DELETE_UNIT", "mefeAPIRequestId": "e7bb7494"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not unmarshallable",
+			evt:     `{"actionType": "CREATE_UNIT", "unitCreationRequestId": "abc"}`,
+			wantErr: true,
+		},
+		{
+			name: "notification",
+			evt:  `{"notification_type": "case_new_message", "notification_id": "ut_notification_message_new-4300"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(json.RawMessage(tt.evt))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Validate() error type = %T, want *ValidationError", err)
+			}
+			if verr.Field != tt.wantField {
+				t.Errorf("Validate() field = %q, want %q", verr.Field, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestValidateEvent(t *testing.T) {
+	evt, err := ioutil.ReadFile("../tests/events/assign_role.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The sample has a numeric mefeAPIRequestId but no idMapUserUnitPermission
+	verr, ok := Validate(evt).(*ValidationError)
+	if !ok || verr.Field != "idMapUserUnitPermission" {
+		t.Errorf("Validate() error = %v, want missing idMapUserUnitPermission", verr)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	got := Attributes(json.RawMessage(`{"actionType": "ASSIGN_ROLE", "mefeAPIRequestId": 1, "unitId": "jAPsg5sZBjSDT9QSD"}`))
+	if len(got) != 2 || got[AttrActionType] != "ASSIGN_ROLE" || got[AttrMEFERequestID] != "1" {
+		t.Errorf("Attributes() = %v", got)
+	}
+	got = Attributes(json.RawMessage(`{"notification_type": "case_new_message", "bz_source_table": "ut_notification_message_new", "notification_id": "ut_notification_message_new-4300"}`))
+	if len(got) != 2 || got[AttrNotificationType] != "case_new_message" || got[AttrSourceTable] != "ut_notification_message_new" {
+		t.Errorf("Attributes() = %v", got)
+	}
+	if got := Attributes(json.RawMessage(`not JSON`)); got != nil {
+		t.Errorf("Attributes() = %v, want nil", got)
+	}
+}
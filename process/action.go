@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// ActionHandler processes one actionType https://github.com/unee-t/lambda2sns/issues/9
+type ActionHandler interface {
+	// Validate checks the payload carries what MEFE and the reply need
+	Validate(evt json.RawMessage) error
+	// Request builds the MEFE API request for the payload
+	Request(evt json.RawMessage) (*http.Request, error)
+	// RetryPolicy bounds the retries of transient MEFE failures
+	RetryPolicy() payload.RetryPolicy
+	// Interpret reads the MEFE response to evt, errors included, as the reply to persist
+	Interpret(evt json.RawMessage, status int, body []byte) (mefeReply, error)
+	// Reply binds the reply to evt to its stored procedure call, without running it
+	Reply(evt json.RawMessage, reply mefeReply) (replyCall, error)
+	// Persist writes the reply to evt back to the enterprise DB
+	Persist(ctx context.Context, db *sql.DB, evt json.RawMessage, reply mefeReply) (replyCall, error)
+}
+
+// actions is the registry of ActionHandler by actionType
+var actions = map[string]ActionHandler{}
+
+func registerAction(actionType string, h ActionHandler) {
+	actions[actionType] = h
+}
+
+// mefeReply is MEFE's answer to an actionType
+type mefeReply struct {
+	ID            string                 `json:"-"`
+	Timestamp     time.Time              `json:"timestamp"`
+	IsCreatedByMe int                    `json:"-"`
+	ErrorMessage  string                 `json:"-"`
+	Fields        map[string]interface{} `json:"-"`
+}
+
+const processAPIPayload = "/api/process-api-payload"
+
+// builtinActions are the actionTypes MEFE always had, ACTION_TYPES adds to them
+var builtinActions = []payload.ActionDefinition{
+	{
+		ActionType:     "CREATE_UNIT",
+		RequestIDField: "unitCreationRequestId",
+		IDField:        "unitMongoId",
+		Procedure:      "ut_creation_unit_mefe_api_reply",
+		Params: []payload.ReplyParam{
+			{Var: "unit_creation_request_id", From: "request.unitCreationRequestId"},
+			{Var: "mefe_unit_id", From: "reply.id"},
+			{Var: "creation_datetime", From: "reply.timestamp"},
+			{Var: "is_created_by_me", From: "reply.isCreatedByMe"},
+			{Var: "mefe_api_error_message", From: "reply.errorMessage"},
+			{Var: "mefe_api_request_id", From: "request.mefeAPIRequestId"},
+		},
+	},
+	{
+		ActionType:     "CREATE_USER",
+		RequestIDField: "userCreationRequestId",
+		IDField:        "userId",
+		Procedure:      "ut_creation_user_mefe_api_reply",
+		Params: []payload.ReplyParam{
+			{Var: "user_creation_request_id", From: "request.userCreationRequestId"},
+			{Var: "mefe_user_id", From: "reply.id"},
+			{Var: "creation_datetime", From: "reply.timestamp"},
+			{Var: "is_created_by_me", From: "reply.isCreatedByMe"},
+			{Var: "mefe_api_error_message", From: "reply.errorMessage"},
+			{Var: "mefe_user_api_key", From: "reply.mefeApiKey"},
+			{Var: "mefe_api_request_id", From: "request.mefeAPIRequestId"},
+		},
+	},
+	{
+		ActionType:     "ASSIGN_ROLE",
+		RequestIDField: "idMapUserUnitPermission",
+		Procedure:      "ut_creation_user_role_association_mefe_api_reply",
+		Params: []payload.ReplyParam{
+			{Var: "id_map_user_unit_permissions", From: "request.idMapUserUnitPermission"},
+			{Var: "creation_datetime", From: "reply.timestamp"},
+			{Var: "mefe_api_error_message", From: "reply.errorMessage"},
+			{Var: "mefe_api_request_id", From: "request.mefeAPIRequestId"},
+		},
+	},
+	{
+		ActionType:     "EDIT_USER",
+		RequestIDField: "updateUserRequestId",
+		Procedure:      "ut_update_user_mefe_api_reply",
+		Params: []payload.ReplyParam{
+			{Var: "update_user_request_id", From: "request.updateUserRequestId"},
+			{Var: "updated_datetime", From: "reply.timestamp"},
+			{Var: "mefe_api_error_message", From: "reply.errorMessage"},
+			{Var: "mefe_api_request_id", From: "request.mefeAPIRequestId"},
+		},
+	},
+	{
+		ActionType:     "EDIT_UNIT",
+		RequestIDField: "updateUnitRequestId",
+		Procedure:      "ut_update_unit_mefe_api_reply",
+		Params: []payload.ReplyParam{
+			{Var: "update_unit_request_id", From: "request.updateUnitRequestId"},
+			{Var: "updated_datetime", From: "reply.timestamp"},
+			{Var: "mefe_api_error_message", From: "reply.errorMessage"},
+			{Var: "mefe_api_request_id", From: "request.mefeAPIRequestId"},
+		},
+	},
+	{
+		ActionType:     "DEASSIGN_ROLE",
+		RequestIDField: "removeUserFromUnitRequestId",
+		Procedure:      "ut_remove_user_role_association_mefe_api_reply",
+		Params: []payload.ReplyParam{
+			{Var: "remove_user_from_unit_request_id", From: "request.removeUserFromUnitRequestId"},
+			{Var: "updated_datetime", From: "reply.timestamp"},
+			{Var: "mefe_api_error_message", From: "reply.errorMessage"},
+			{Var: "mefe_api_request_id", From: "request.mefeAPIRequestId"},
+		},
+	},
+}
+
+// defineActions registers an ActionHandler for each definition
+func defineActions(defs []payload.ActionDefinition) {
+	payload.Define(defs)
+	for _, d := range defs {
+		registerAction(d.ActionType, mefeAction{d})
+	}
+}
+
+// mefeAction is an ActionHandler for a payload.ActionDefinition: it posts
+// the payload to MEFE and replies through the definition's stored procedure
+type mefeAction struct {
+	payload.ActionDefinition
+}
+
+func (a mefeAction) endpoint() string {
+	if a.Endpoint == "" {
+		return processAPIPayload
+	}
+	return a.Endpoint
+}
+
+func (a mefeAction) Validate(evt json.RawMessage) error {
+	_, err := payload.ParseActionType(evt)
+	return err
+}
+
+func (a mefeAction) Request(evt json.RawMessage) (*http.Request, error) {
+	token := string(conf.Process.MEFE.AccessToken)
+	if token == "" {
+		return nil, fmt.Errorf("missing API_ACCESS_TOKEN credential")
+	}
+	url := conf.Process.MEFE.BaseURL + a.endpoint() + "?accessToken=" + token
+	req, err := http.NewRequest("POST", url, strings.NewReader(string(evt)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+func (a mefeAction) RetryPolicy() payload.RetryPolicy {
+	return a.Retry.Or(defaultRetry)
+}
+
+func (a mefeAction) Interpret(evt json.RawMessage, status int, body []byte) (r mefeReply, err error) {
+	// https://github.com/unee-t/lambda2sns/issues/9#issuecomment-474238691
+	switch status {
+	case http.StatusOK:
+		r.IsCreatedByMe = 0
+	case http.StatusCreated:
+		r.IsCreatedByMe = 1
+	default:
+		// We don't stop here since we want to feedback errors to db
+		r.ErrorMessage = fmt.Sprintf("Error: %d %s from MEFE: %s, Response: %s from Request: %s", status, http.StatusText(status), a.endpoint(), string(body), string(evt))
+		return r, nil
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(body, &r.Fields); err != nil {
+		return r, err
+	}
+	idField := a.IDField
+	if idField == "" {
+		idField = "id"
+	}
+	if id, ok := r.Fields[idField]; ok && id != nil {
+		r.ID = fmt.Sprint(id)
+	}
+	return r, nil
+}
+
+func (a mefeAction) Persist(ctx context.Context, db *sql.DB, evt json.RawMessage, r mefeReply) (replyCall, error) {
+	call, err := a.Reply(evt, r)
+	if err != nil {
+		return call, err
+	}
+	return call, call.exec(ctx, db)
+}
+
+// Reply binds the definition's params to the payload and MEFE's reply
+func (a mefeAction) Reply(evt json.RawMessage, r mefeReply) (call replyCall, err error) {
+	call.Procedure = a.Procedure
+	dec := json.NewDecoder(strings.NewReader(string(evt)))
+	dec.UseNumber()
+	var rec map[string]interface{}
+	if err := dec.Decode(&rec); err != nil {
+		return call, err
+	}
+	for _, p := range a.Params {
+		call.Vars = append(call.Vars, p.Var)
+		call.Args = append(call.Args, r.value(rec, p.From))
+	}
+	return call, nil
+}
+
+// value resolves a payload.ReplyParam From against the payload rec
+func (r mefeReply) value(rec map[string]interface{}, from string) interface{} {
+	switch from {
+	case "reply.id":
+		return r.ID
+	case "reply.timestamp":
+		return r.Timestamp.Format(sqlTimeLayout)
+	case "reply.isCreatedByMe":
+		return r.IsCreatedByMe
+	case "reply.errorMessage":
+		return r.ErrorMessage
+	}
+	var v interface{}
+	if strings.HasPrefix(from, "request.") {
+		v = rec[strings.TrimPrefix(from, "request.")]
+	} else {
+		v = r.Fields[strings.TrimPrefix(from, "reply.")]
+	}
+	switch v := v.(type) {
+	case nil:
+		// MEFE errors leave reply fields unset, bind empty as before
+		return ""
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		return v.String()
+	case float64:
+		return v
+	case string:
+		return v
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+func init() {
+	defineActions(builtinActions)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+func Test_actionTypeDB(t *testing.T) {
+	tests := []struct {
+		name     string
+		evt      string
+		status   int
+		response string
+		wantSET  string
+		wantErr  bool
+	}{
+		{
+			name:     "CREATE_UNIT created",
+			evt:      `{"actionType": "CREATE_UNIT", "mefeAPIRequestId": "e7bb7494", "unitCreationRequestId": 4771, "name": "HK_HKG_12ST - 1"}`,
+			status:   http.StatusCreated,
+			response: `{"unitMongoId": "jAPsg5sZBjSDT9QSD", "timestamp": "2019-08-23T07:54:20Z"}`,
+			wantSET:  "[4771 jAPsg5sZBjSDT9QSD 2019-08-23 07:54:20 1  e7bb7494]",
+		},
+		{
+			name:     "CREATE_USER existing",
+			evt:      `{"actionType": "CREATE_USER", "mefeAPIRequestId": "e7bb7495", "userCreationRequestId": 20}`,
+			status:   http.StatusOK,
+			response: `{"userId": "wQY75SMMHbMv5jnhe", "mefeApiKey": "key", "timestamp": "2019-08-23T07:54:20Z"}`,
+			wantSET:  "[20 wQY75SMMHbMv5jnhe 2019-08-23 07:54:20 0  key e7bb7495]",
+		},
+		{
+			name:     "EDIT_UNIT rejected",
+			evt:      `{"actionType": "EDIT_UNIT", "mefeAPIRequestId": "e7bb7496", "updateUnitRequestId": 1054}`,
+			status:   http.StatusBadRequest,
+			response: `unit not found`,
+			wantSET:  "[1054 0001-01-01 00:00:00 Error: 400 Bad Request from MEFE: /api/process-api-payload, Response: unit not found from Request: ",
+		},
+		{
+			name:     "DEASSIGN_ROLE MEFE down",
+			evt:      `{"actionType": "DEASSIGN_ROLE", "mefeAPIRequestId": "e7bb7497", "removeUserFromUnitRequestId": 1}`,
+			status:   http.StatusServiceUnavailable,
+			response: `down`,
+			wantSET:  "[1 0001-01-01 00:00:00 Error: 503 Service Unavailable",
+			wantErr:  true,
+		},
+		{
+			name:    "unknown type",
+			evt:     `{"actionType": "DELETE_UNIT", "mefeAPIRequestId": "e7bb7498"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetBreaker(t)
+			noSleep(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+			useMEFE(t, srv.URL)
+			var r *recorder
+			DB, r = openRecorder(t)
+
+			c := withRequestID{log: log.WithFields(log.Fields{})}
+			err := c.actionTypeDB(context.Background(), []byte(tt.evt))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("actionTypeDB() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			stmts := r.Statements()
+			if tt.wantSET == "" {
+				if len(stmts) != 0 {
+					t.Errorf("actionTypeDB() ran %v", stmts)
+				}
+				return
+			}
+			if len(stmts) < 2 || !strings.Contains(stmts[1], tt.wantSET) {
+				t.Errorf("actionTypeDB() ran %v, want SET %s", stmts, tt.wantSET)
+			}
+		})
+	}
+}
+
+func Test_defineActions(t *testing.T) {
+	defer delete(actions, "DELETE_UNIT")
+	defer delete(payload.RequiredID, "DELETE_UNIT")
+	defineActions([]payload.ActionDefinition{{
+		ActionType:     "DELETE_UNIT",
+		RequestIDField: "deleteUnitRequestId",
+		Endpoint:       "/api/units/delete",
+		IDField:        "unitMongoId",
+		Procedure:      "ut_delete_unit_mefe_api_reply",
+		Params: []payload.ReplyParam{
+			{Var: "delete_unit_request_id", From: "request.deleteUnitRequestId"},
+			{Var: "mefe_unit_id", From: "reply.id"},
+			{Var: "deleted_by", From: "reply.deletedBy"},
+			{Var: "mefe_api_error_message", From: "reply.errorMessage"},
+		},
+	}})
+
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"unitMongoId": "jAPsg5sZBjSDT9QSD", "deletedBy": "admin"}`))
+	}))
+	defer srv.Close()
+	useMEFE(t, srv.URL)
+	var r *recorder
+	DB, r = openRecorder(t)
+
+	c := withRequestID{log: log.WithFields(log.Fields{})}
+	err := c.actionTypeDB(context.Background(), []byte(`{"actionType": "DELETE_UNIT", "mefeAPIRequestId": "e7bb7499", "deleteUnitRequestId": 12}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/api/units/delete" {
+		t.Errorf("posted to %s", path)
+	}
+	stmts := r.Statements()
+	if len(stmts) < 3 || !strings.Contains(stmts[1], "[12 jAPsg5sZBjSDT9QSD admin ]") || !strings.Contains(stmts[2], "ut_delete_unit_mefe_api_reply") {
+		t.Errorf("actionTypeDB() ran %v", stmts)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// mefeClient calls MEFE, unlike http.DefaultClient it gives up on a hung MEFE
+var mefeClient = &http.Client{
+	Timeout: 8 * time.Second,
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 3 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: 6 * time.Second,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// errCircuitOpen fails a MEFE call fast while MEFE is known to be down
+var errCircuitOpen = errors.New("MEFE circuit open")
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open"
+)
+
+// breaker opens after threshold consecutive MEFE failures. After cooldown a
+// single half-open probe decides whether it closes again. The Lambda
+// container keeps it across invocations.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// mefeBreaker guards every MEFE endpoint, they share a host
+var mefeBreaker = newBreaker(5, 30*time.Second)
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now, state: breakerClosed}
+}
+
+// Allow returns errCircuitOpen unless a call may go through
+func (b *breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.transition(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Record counts the outcome of an allowed call
+func (b *breaker) Record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.transition(breakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		if b.state != breakerOpen {
+			b.transition(breakerOpen)
+		}
+	}
+}
+
+// State reports the state for logs
+func (b *breaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// RetryAfter is how long until the next half-open probe
+func (b *breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	if d := b.cooldown - b.now().Sub(b.openedAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (b *breaker) transition(to breakerState) {
+	log.WithFields(log.Fields{
+		"from":     b.state,
+		"to":       to,
+		"failures": b.failures,
+	}).Warn("MEFE circuit breaker")
+	b.state = to
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// resetBreaker gives the test a closed mefeBreaker
+func resetBreaker(t *testing.T) {
+	old := mefeBreaker
+	mefeBreaker = newBreaker(5, 30*time.Second)
+	t.Cleanup(func() { mefeBreaker = old })
+}
+
+func Test_breaker(t *testing.T) {
+	now := time.Date(2019, 9, 3, 0, 0, 0, 0, time.UTC)
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Record(false)
+	if b.Allow() != nil || b.State() != breakerClosed {
+		t.Fatalf("one failure opened the breaker")
+	}
+	b.Record(false)
+	if b.Allow() != errCircuitOpen || b.State() != breakerOpen {
+		t.Fatalf("breaker %s after threshold", b.State())
+	}
+	if b.RetryAfter() != time.Minute {
+		t.Errorf("RetryAfter() = %v", b.RetryAfter())
+	}
+
+	now = now.Add(time.Minute)
+	if b.Allow() != nil || b.State() != breakerHalfOpen {
+		t.Fatalf("breaker %s after cooldown", b.State())
+	}
+	if b.Allow() != errCircuitOpen {
+		t.Errorf("half-open breaker allowed a second probe")
+	}
+	b.Record(false)
+	if b.State() != breakerOpen {
+		t.Fatalf("failed probe left breaker %s", b.State())
+	}
+
+	now = now.Add(time.Minute)
+	b.Allow()
+	b.Record(true)
+	if b.Allow() != nil || b.State() != breakerClosed {
+		t.Errorf("successful probe left breaker %s", b.State())
+	}
+}
+
+type fakeRequeuer []string
+
+func (q *fakeRequeuer) Requeue(ctx context.Context, record SQSrecord, delay time.Duration) error {
+	*q = append(*q, fmt.Sprintf("%s %v", record.MessageID, delay))
+	return nil
+}
+
+func (q *fakeRequeuer) DeadLetter(ctx context.Context, record SQSrecord, reason error) error {
+	*q = append(*q, fmt.Sprintf("%s DLQ", record.MessageID))
+	return nil
+}
+
+func Test_handlerCircuitOpen(t *testing.T) {
+	resetBreaker(t)
+	received, close := mefe(http.StatusOK)
+	defer close()
+	now := time.Now()
+	mefeBreaker.now = func() time.Time { return now }
+	for i := 0; i < mefeBreaker.threshold; i++ {
+		mefeBreaker.Record(false)
+	}
+	q := &fakeRequeuer{}
+	requeue = q
+	defer func() { requeue = nil }()
+
+	res, err := handler(context.Background(), sqsEvent(
+		caseNewMessage,
+		`{"actionType": "CREATE_UNIT", "mefeAPIRequestId": "e7bb7494", "unitCreationRequestId": 4771}`,
+	))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if fmt.Sprint(res.BatchItemFailures) != "[{m0} {m1}]" {
+		t.Errorf("handler() batchItemFailures = %v", res.BatchItemFailures)
+	}
+	if len(*received) != 0 {
+		t.Errorf("MEFE received %v while the circuit is open", *received)
+	}
+	if fmt.Sprint(*q) != "[m0 30s m1 30s]" {
+		t.Errorf("requeued %v", *q)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// s3Store fetches the oversized payloads push left in CLAIM_CHECK_BUCKET
+type s3Store struct {
+	svc    *s3.S3
+	bucket string
+}
+
+func (s s3Store) Put(ctx context.Context, key string, body []byte) error {
+	req := s.svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	req.SetContext(ctx)
+	_, err := req.Send()
+	return err
+}
+
+func (s s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	req := s.svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	req.SetContext(ctx)
+	res, err := req.Send()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+func (s s3Store) Delete(ctx context.Context, key string) error {
+	req := s.svc.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	req.SetContext(ctx)
+	_, err := req.Send()
+	return err
+}
+
+// newBlobStore prefers CLAIM_CHECK_BUCKET, then CLAIM_CHECK_DIR for local dev
+func newBlobStore(cfg aws.Config) payload.BlobStore {
+	if conf.ClaimCheck.Bucket != "" {
+		return s3Store{svc: s3.New(cfg), bucket: conf.ClaimCheck.Bucket}
+	}
+	if conf.ClaimCheck.Dir != "" {
+		return payload.DirStore{Dir: conf.ClaimCheck.Dir}
+	}
+	return nil
+}
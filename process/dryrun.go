@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// dryRun is true with DRY_RUN or the dryRun message attribute. A dry run
+// validates the message and logs the MEFE request and the reply call it
+// would make, touching neither MEFE nor the DB.
+func dryRun(attrs map[string]string) bool {
+	if conf.Process.DryRun {
+		return true
+	}
+	on, _ := strconv.ParseBool(attrs[payload.AttrDryRun])
+	return on
+}
+
+// dryRunReply stands in for MEFE's answer when binding the reply call
+func dryRunReply() mefeReply {
+	return mefeReply{ID: "<MEFE id>", Timestamp: time.Now().UTC().Truncate(time.Second), IsCreatedByMe: 1}
+}
+
+// dryRunAction logs what actionTypeDB would send to MEFE and call in the DB
+func (c withRequestID) dryRunAction(h ActionHandler, evt json.RawMessage) error {
+	req, err := h.Request(evt)
+	if err != nil {
+		return permanent(err)
+	}
+	dump, err := dumpRequest(req)
+	if err != nil {
+		return permanent(err)
+	}
+	call, err := h.Reply(evt, dryRunReply())
+	if err != nil {
+		return permanent(err)
+	}
+	c.trace.dryRun(dump, call.String())
+	c.log.WithFields(log.Fields{
+		"request": dump,
+		"sql":     call.String(),
+	}).Info("dry run")
+	return nil
+}
+
+// dryRunRequest logs what postChangeMessage would send to MEFE
+func (c withRequestID) dryRunRequest(build func() (*http.Request, error)) error {
+	req, err := build()
+	if err != nil {
+		return permanent(err)
+	}
+	dump, err := dumpRequest(req)
+	if err != nil {
+		return permanent(err)
+	}
+	c.trace.dryRun(dump, "")
+	c.log.WithField("request", dump).Info("dry run")
+	return nil
+}
+
+// dumpRequest is req as it would go on the wire, the access token redacted
+func dumpRequest(req *http.Request) (string, error) {
+	out, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		return "", err
+	}
+	return redact(string(out)), nil
+}
+
+// redact hides the MEFE access token in s
+func redact(s string) string {
+	token := string(conf.Process.MEFE.AccessToken)
+	if token == "" {
+		return s
+	}
+	return strings.Replace(s, token, "[redacted]", -1)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+func Test_dryRun(t *testing.T) {
+	resetBreaker(t)
+	received, close := mefe(http.StatusOK)
+	defer close()
+	var r *recorder
+	DB, r = openRecorder(t)
+	idempotency = newMemoryIdempotency(time.Hour)
+	defer func() { idempotency = nil }()
+
+	tests := []struct {
+		name     string
+		evt      string
+		env      bool
+		attrs    map[string]string
+		wantReq  string
+		wantCall string
+	}{
+		{
+			name:     "actionType by attribute",
+			evt:      `{"actionType": "CREATE_UNIT", "mefeAPIRequestId": "e7bb7494", "unitCreationRequestId": 4771}`,
+			attrs:    map[string]string{payload.AttrDryRun: "true"},
+			wantReq:  "POST /api/process-api-payload?accessToken=[redacted] HTTP/1.1",
+			wantCall: `@unit_creation_request_id = 4771, @mefe_unit_id = "<MEFE id>"`,
+		},
+		{
+			name:     "notification by DRY_RUN",
+			evt:      caseNewMessage,
+			env:      true,
+			wantReq:  "POST /api/db-change-message/process?accessToken=[redacted] HTTP/1.1",
+			wantCall: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(on bool) { conf.Process.DryRun = on }(conf.Process.DryRun)
+			conf.Process.DryRun = tt.env
+			tr := &trace{}
+			c := withRequestID{log: log.WithFields(log.Fields{}), trace: tr}
+			if err := c.process(context.Background(), []byte(tt.evt), tt.attrs); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(tr.Request, tt.wantReq) || !strings.Contains(tr.Request, "Authorization: Bearer [redacted]") ||
+				strings.Contains(tr.Request, "secret") || !strings.Contains(tr.Request, "\r\n\r\n{") {
+				t.Errorf("request = %s", tr.Request)
+			}
+			if !strings.Contains(tr.Call, tt.wantCall) {
+				t.Errorf("call = %s, want %s", tr.Call, tt.wantCall)
+			}
+		})
+	}
+	if len(*received) != 0 || len(r.Statements()) != 0 {
+		t.Errorf("dry run reached MEFE %v or the DB %v", *received, r.Statements())
+	}
+	if done, err := idempotency.Claim(context.Background(), "mefeAPIRequestId:e7bb7494"); done || err != nil {
+		t.Errorf("Claim() = %v, %v after a dry run", done, err)
+	}
+}
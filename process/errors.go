@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// ErrorKind says why processing a message failed
+type ErrorKind int
+
+const (
+	// Transient failures may succeed later, the message is retried
+	Transient ErrorKind = iota
+	// Permanent failures will fail again, the message is dead-lettered
+	Permanent
+	// Duplicate messages were already processed, the message is acknowledged
+	Duplicate
+	// Invalid payloads can never be processed, the message is dead-lettered
+	Invalid
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case Permanent:
+		return "permanent"
+	case Duplicate:
+		return "duplicate"
+	case Invalid:
+		return "invalid"
+	}
+	return "transient"
+}
+
+// ProcessError is a failure of a processing stage with its ErrorKind
+type ProcessError struct {
+	Kind ErrorKind
+	Err  error
+	// Delay holds a Transient message back at least this long
+	Delay time.Duration
+}
+
+func (e *ProcessError) Error() string { return e.Kind.String() + ": " + e.Err.Error() }
+
+func (e *ProcessError) Unwrap() error { return e.Err }
+
+func transient(err error, delay time.Duration) error {
+	return &ProcessError{Kind: Transient, Err: err, Delay: delay}
+}
+
+func permanent(err error) error { return &ProcessError{Kind: Permanent, Err: err} }
+
+func duplicate(err error) error { return &ProcessError{Kind: Duplicate, Err: err} }
+
+func invalid(err error) error { return &ProcessError{Kind: Invalid, Err: err} }
+
+// classify finds the ProcessError in err. Errors the stages did not
+// classify are Transient, so nothing is lost by mistake.
+func classify(err error) *ProcessError {
+	var perr *ProcessError
+	var verr *payload.ValidationError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &perr):
+		return perr
+	case errors.As(err, &verr):
+		return &ProcessError{Kind: Invalid, Err: err}
+	case errors.Is(err, errCircuitOpen):
+		delay := mefeBreaker.RetryAfter()
+		if delay < minRequeueDelay {
+			delay = minRequeueDelay
+		}
+		return &ProcessError{Kind: Transient, Err: err, Delay: delay}
+	case errors.Is(err, errInProgress):
+		return &ProcessError{Kind: Transient, Err: err, Delay: idempotencyLease}
+	}
+	return &ProcessError{Kind: Transient, Err: err}
+}
+
+// outcome is what the handler does with a processed message
+type outcome string
+
+const (
+	ack        outcome = "ack"
+	retry      outcome = "retry"
+	delay      outcome = "delay"
+	deadLetter outcome = "dead-letter"
+)
+
+// decide maps the result of processing a message to its outcome, the one
+// place where retry and DLQ decisions are made
+func decide(err error) (outcome, *ProcessError) {
+	perr := classify(err)
+	if perr == nil {
+		return ack, nil
+	}
+	switch perr.Kind {
+	case Duplicate:
+		return ack, perr
+	case Permanent, Invalid:
+		return deadLetter, perr
+	}
+	if perr.Delay > 0 {
+		return delay, perr
+	}
+	return retry, perr
+}
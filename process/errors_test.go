@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+func Test_decide(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want outcome
+	}{
+		{"processed", nil, ack},
+		{"duplicate", duplicate(errors.New("Error 1062: Duplicate entry")), ack},
+		{"invalid", &payload.ValidationError{Field: "mefeAPIRequestId", Reason: "missing"}, deadLetter},
+		{"wrapped invalid", fmt.Errorf("claim: %w", invalid(errors.New("not JSON"))), deadLetter},
+		{"permanent", permanent(errors.New("400")), deadLetter},
+		{"transient", transient(errors.New("503"), 0), retry},
+		{"unclassified", errors.New("connection reset"), retry},
+		{"circuit open", errCircuitOpen, delay},
+		{"in progress", errInProgress, delay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := decide(tt.err); got != tt.want {
+				t.Errorf("decide() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_handlerOutcomes(t *testing.T) {
+	resetBreaker(t)
+	noSleep(t)
+	received, close := mefe(http.StatusBadRequest)
+	defer close()
+	q := &fakeRequeuer{}
+	requeue = q
+	defer func() { requeue = nil }()
+
+	res, err := handler(context.Background(), sqsEvent(
+		`not JSON`,
+		caseNewMessage,
+		`{"notification_type": "case_deleted", "notification_id": "ut_notification_case_deleted-1"}`,
+	))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(res.BatchItemFailures) != 0 {
+		t.Errorf("handler() batchItemFailures = %v, want none", res.BatchItemFailures)
+	}
+	if fmt.Sprint(*q) != "[m0 DLQ m1 DLQ m2 DLQ]" {
+		t.Errorf("requeued %v", *q)
+	}
+	if len(*received) != 1 {
+		t.Errorf("MEFE received %d notifications, want 1", len(*received))
+	}
+}
+
+func Test_deadLetterInput(t *testing.T) {
+	var record SQSrecord
+	record.MessageID, record.Body = "m0", caseNewMessage
+	record.Attributes.ApproximateReceiveCount = "3"
+	record.Attributes.MessageGroupID = "case-3293"
+	reason := permanent(errors.New(strings.Repeat("é", maxDeadLetterReason)))
+
+	input := deadLetterInput("https://sqs.ap-southeast-1.amazonaws.com/812644853088/dlq.fifo", record, reason)
+	got := aws.StringValue(input.MessageAttributes[payload.AttrDeadLetterReason].StringValue)
+	if len(got) > maxDeadLetterReason || !utf8.ValidString(got) || !strings.HasPrefix(got, "permanent: é") {
+		t.Errorf("reason of %d bytes = %.20s…", len(got), got)
+	}
+	if n := input.MessageAttributes[payload.AttrReceiveCount]; aws.StringValue(n.StringValue) != "3" || aws.StringValue(n.DataType) != "Number" {
+		t.Errorf("receive count = %+v", n)
+	}
+	if aws.StringValue(input.MessageGroupId) != "case-3293" || aws.StringValue(input.MessageDeduplicationId) != "m0" {
+		t.Errorf("FIFO group %v, deduplication %v", input.MessageGroupId, input.MessageDeduplicationId)
+	}
+
+	if input := deadLetterInput("https://sqs.ap-southeast-1.amazonaws.com/812644853088/dlq", record, reason); input.MessageGroupId != nil || input.MessageDeduplicationId != nil {
+		t.Errorf("standard DLQ input = %+v", input)
+	}
+}
@@ -8,7 +8,13 @@ require (
 	github.com/aws/aws-sdk-go-v2 v0.11.0
 	github.com/go-sql-driver/mysql v1.4.1
 	github.com/unee-t/env v0.0.0-20190513035325-a55bf10999d5
+	github.com/unee-t/lambda2sqs/config v0.0.0
+	github.com/unee-t/lambda2sqs/payload v0.0.0
 	google.golang.org/appengine v1.6.2 // indirect
 )
 
 replace github.com/aws/aws-sdk-go-v2 => github.com/aws/aws-sdk-go-v2 v0.7.0
+
+replace github.com/unee-t/lambda2sqs/payload => ../payload
+
+replace github.com/unee-t/lambda2sqs/config => ../config
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/unee-t/lambda2sqs/config"
+)
+
+// errInProgress means another delivery of the message holds its claim
+var errInProgress = errors.New("message is being processed by another delivery")
+
+// idempotencyStore records which messages were processed, so redelivered and
+// duplicated messages skip MEFE and the DB
+type idempotencyStore interface {
+	// Claim returns done when key was already processed within the
+	// retention, errInProgress when another delivery holds it
+	Claim(ctx context.Context, key string) (done bool, err error)
+	// Done marks a claimed key processed
+	Done(ctx context.Context, key string) error
+	// Release drops the claim of a failed key, so it is retried
+	Release(ctx context.Context, key string) error
+	// Purge forgets keys older than the retention
+	Purge(ctx context.Context) error
+}
+
+// idempotency is nil unless IDEMPOTENCY_TABLE is set
+var idempotency idempotencyStore
+
+// idempotencyLease is how long a claim survives a delivery that died, longer than the Process timeout
+const idempotencyLease = 2 * time.Minute
+
+// newIdempotencyStore is nil when no table is configured
+func newIdempotencyStore(db *sql.DB, c config.Idempotency) idempotencyStore {
+	if c.Table == "" {
+		return nil
+	}
+	return sqlIdempotency{db: db, table: c.Table, retention: c.Retention.Duration}
+}
+
+// sqlIdempotency keeps the keys in an enterprise DB table, see idempotency.sql
+type sqlIdempotency struct {
+	db        *sql.DB
+	table     string
+	retention time.Duration
+}
+
+func (s sqlIdempotency) Claim(ctx context.Context, key string) (bool, error) {
+	// Expired keys and stale claims are as good as never seen
+	_, err := s.db.ExecContext(ctx, "DELETE FROM "+s.table+" WHERE message_key = ? AND updated_at < UTC_TIMESTAMP() - INTERVAL IF(state = 'done', ?, ?) SECOND",
+		key, int64(s.retention/time.Second), int64(idempotencyLease/time.Second))
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, "INSERT IGNORE INTO "+s.table+" (message_key, state, updated_at) VALUES (?, 'processing', UTC_TIMESTAMP())", key)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return false, err
+	}
+	var state string
+	err = s.db.QueryRowContext(ctx, "SELECT state FROM "+s.table+" WHERE message_key = ?", key).Scan(&state)
+	if err == sql.ErrNoRows || (err == nil && state != "done") {
+		return false, errInProgress
+	}
+	return err == nil, err
+}
+
+func (s sqlIdempotency) Done(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE "+s.table+" SET state = 'done', updated_at = UTC_TIMESTAMP() WHERE message_key = ?", key)
+	return err
+}
+
+func (s sqlIdempotency) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM "+s.table+" WHERE message_key = ? AND state = 'processing'", key)
+	return err
+}
+
+func (s sqlIdempotency) Purge(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM "+s.table+" WHERE state = 'done' AND updated_at < UTC_TIMESTAMP() - INTERVAL ? SECOND LIMIT 1000",
+		int64(s.retention/time.Second))
+	return err
+}
+
+// memoryIdempotency keeps the keys in memory, for tests and local runs
+type memoryIdempotency struct {
+	retention time.Duration
+	now       func() time.Time
+
+	mu   sync.Mutex
+	keys map[string]idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	done    bool
+	updated time.Time
+}
+
+func newMemoryIdempotency(retention time.Duration) *memoryIdempotency {
+	return &memoryIdempotency{retention: retention, now: time.Now, keys: map[string]idempotencyEntry{}}
+}
+
+func (m *memoryIdempotency) Claim(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.keys[key]
+	switch {
+	case ok && e.done && m.now().Sub(e.updated) < m.retention:
+		return true, nil
+	case ok && !e.done && m.now().Sub(e.updated) < idempotencyLease:
+		return false, errInProgress
+	}
+	m.keys[key] = idempotencyEntry{updated: m.now()}
+	return false, nil
+}
+
+func (m *memoryIdempotency) Done(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key] = idempotencyEntry{done: true, updated: m.now()}
+	return nil
+}
+
+func (m *memoryIdempotency) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.keys[key].done {
+		delete(m.keys, key)
+	}
+	return nil
+}
+
+func (m *memoryIdempotency) Purge(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, e := range m.keys {
+		if e.done && m.now().Sub(e.updated) >= m.retention {
+			delete(m.keys, key)
+		}
+	}
+	return nil
+}
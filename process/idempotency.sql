@@ -0,0 +1,8 @@
+-- Processing state per mefeAPIRequestId / notification_id, set IDEMPOTENCY_TABLE to use it
+CREATE TABLE IF NOT EXISTS lambda2sqs_idempotency (
+  message_key VARCHAR(255) NOT NULL,
+  state ENUM('processing', 'done') NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (message_key),
+  KEY updated_at (updated_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_520_ci;
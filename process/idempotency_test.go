@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+func Test_memoryIdempotency(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2019, 9, 3, 0, 0, 0, 0, time.UTC)
+	m := newMemoryIdempotency(time.Hour)
+	m.now = func() time.Time { return now }
+
+	if done, err := m.Claim(ctx, "k"); done || err != nil {
+		t.Fatalf("Claim() = %v, %v on a new key", done, err)
+	}
+	if _, err := m.Claim(ctx, "k"); err != errInProgress {
+		t.Errorf("Claim() error = %v on a claimed key", err)
+	}
+	m.Release(ctx, "k")
+	m.Claim(ctx, "k")
+	m.Done(ctx, "k")
+	if done, err := m.Claim(ctx, "k"); !done || err != nil {
+		t.Errorf("Claim() = %v, %v on a done key", done, err)
+	}
+	now = now.Add(time.Hour)
+	m.Purge(ctx)
+	if done, err := m.Claim(ctx, "k"); done || err != nil {
+		t.Errorf("Claim() = %v, %v after the retention", done, err)
+	}
+}
+
+func Test_sqlIdempotency(t *testing.T) {
+	db, r := openRecorder(t)
+	s := sqlIdempotency{db: db, table: "lambda2sqs_idempotency", retention: time.Hour}
+	state := "done"
+	r.answer = func(query string) (int64, driver.Value) {
+		if strings.HasPrefix(query, "SELECT") {
+			return 0, state
+		}
+		return 0, nil
+	}
+	ctx := context.Background()
+	if done, err := s.Claim(ctx, "mefeAPIRequestId:e7bb7494"); !done || err != nil {
+		t.Errorf("Claim() = %v, %v on a done key", done, err)
+	}
+	state = "processing"
+	if _, err := s.Claim(ctx, "mefeAPIRequestId:e7bb7494"); err != errInProgress {
+		t.Errorf("Claim() error = %v on a claimed key", err)
+	}
+	r.answer = func(query string) (int64, driver.Value) { return 1, nil }
+	if done, err := s.Claim(ctx, "mefeAPIRequestId:e7bb7494"); done || err != nil {
+		t.Errorf("Claim() = %v, %v on a new key", done, err)
+	}
+	stmts := r.Statements()
+	if last := stmts[len(stmts)-1]; !strings.Contains(last, "INSERT IGNORE INTO lambda2sqs_idempotency") || !strings.Contains(last, "[mefeAPIRequestId:e7bb7494]") {
+		t.Errorf("Claim() ran %s", last)
+	}
+	if !strings.Contains(stmts[0], "[mefeAPIRequestId:e7bb7494 3600 120]") {
+		t.Errorf("Claim() expired with %s", stmts[0])
+	}
+}
+
+func Test_processIdempotent(t *testing.T) {
+	resetBreaker(t)
+	noSleep(t)
+	received, close := mefe(http.StatusOK)
+	defer close()
+	idempotency = newMemoryIdempotency(time.Hour)
+	defer func() { idempotency = nil }()
+
+	res, err := handler(context.Background(), sqsEvent(caseNewMessage, caseNewMessage))
+	if err != nil || len(res.BatchItemFailures) != 0 {
+		t.Fatalf("handler() = %v, %v", res, err)
+	}
+	if len(*received) != 1 {
+		t.Errorf("MEFE received %d notifications, want 1", len(*received))
+	}
+
+	// A failed message is released for its redelivery
+	failing, closeFailing := mefe(http.StatusServiceUnavailable)
+	defer closeFailing()
+	evt := `{"actionType": "EDIT_USER", "mefeAPIRequestId": "e7bb7500", "updateUserRequestId": 7}`
+	DB, _ = openRecorder(t)
+	res, _ = handler(context.Background(), sqsEvent(evt))
+	if len(res.BatchItemFailures) != 1 {
+		t.Fatalf("handler() = %v, want a failure", res)
+	}
+	if done, err := idempotency.Claim(context.Background(), "mefeAPIRequestId:e7bb7500"); done || err != nil {
+		t.Errorf("Claim() = %v, %v after a failure", done, err)
+	}
+	if len(*failing) != defaultRetry.MaxAttempts {
+		t.Errorf("MEFE received %d requests", len(*failing))
+	}
+}
+
+func Test_processIdempotentDuplicate(t *testing.T) {
+	resetBreaker(t)
+	noSleep(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "jAPsg5sZBjSDT9QSD", "timestamp": "2019-08-23T07:54:20Z"}`))
+	}))
+	defer srv.Close()
+	useMEFE(t, srv.URL)
+	idempotency = newMemoryIdempotency(time.Hour)
+	defer func() { idempotency = nil }()
+	var r *recorder
+	DB, r = openRecorder(t)
+	r.fail, r.code = "CALL", 1062
+
+	// The reply procedure already recorded this request
+	evt := `{"actionType": "EDIT_USER", "mefeAPIRequestId": "e7bb7501", "updateUserRequestId": 7}`
+	res, err := handler(context.Background(), sqsEvent(evt))
+	if err != nil || len(res.BatchItemFailures) != 0 {
+		t.Fatalf("handler() = %v, %v, want the duplicate acknowledged", res, err)
+	}
+	if done, err := idempotency.Claim(context.Background(), "mefeAPIRequestId:e7bb7501"); !done || err != nil {
+		t.Errorf("Claim() = %v, %v after a duplicate, want done", done, err)
+	}
+}
+
+func Test_processIdempotentClaimCheck(t *testing.T) {
+	idempotency = newMemoryIdempotency(time.Hour)
+	defer func() { idempotency = nil }()
+	ctx := context.Background()
+	idempotency.Claim(ctx, "notification_id:ut_notification_message_new-4300")
+	idempotency.Done(ctx, "notification_id:ut_notification_message_new-4300")
+
+	// blobs is nil, so fetching the payload would fail
+	tr := &trace{}
+	c := withRequestID{log: log.WithFields(log.Fields{}), trace: tr}
+	pointer := `{"claimCheck": {"key": "payloads/gone.json", "size": 300000, "idempotencyKey": "notification_id:ut_notification_message_new-4300"}}`
+	if err := c.process(ctx, []byte(pointer), nil); err != nil || !tr.Skipped {
+		t.Errorf("process() = %v, skipped %v, want a duplicate skipped before its fetch", err, tr.Skipped)
+	}
+}
+
+func Test_processReleasesClaimCheck(t *testing.T) {
+	resetBreaker(t)
+	_, close := mefe(http.StatusOK)
+	defer close()
+	idempotency = newMemoryIdempotency(time.Hour)
+	defer func() { idempotency = nil }()
+	dir, err := ioutil.TempDir("", "claimcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobs = payload.DirStore{Dir: dir}
+	defer func() { blobs = nil }()
+
+	ctx := context.Background()
+	pointer, err := payload.NewClaimCheck(ctx, blobs, []byte(caseNewMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := withRequestID{log: log.WithFields(log.Fields{})}
+	if err := c.process(ctx, pointer, nil); err != nil {
+		t.Fatalf("process() error = %v", err)
+	}
+	if _, err := blobs.Get(ctx, payload.ParseClaimCheck(pointer).Key); err == nil {
+		t.Error("payload still stored after its message was processed")
+	}
+	// A duplicate is skipped on its key alone
+	if err := c.process(ctx, pointer, nil); err != nil {
+		t.Errorf("process() duplicate error = %v", err)
+	}
+}
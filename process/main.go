@@ -6,95 +6,110 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/apex/log"
 	jsonhandler "github.com/apex/log/handlers/json"
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambdacontext"
-	"github.com/aws/aws-sdk-go-v2/aws/external"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/unee-t/env"
+	"github.com/unee-t/lambda2sqs/config"
+	"github.com/unee-t/lambda2sqs/payload"
 )
 
 type withRequestID struct {
 	log *log.Entry
+	// trace is only set by replay
+	trace *trace
+	// dryRun stops at logging the MEFE request and reply call
+	dryRun bool
 }
 
-var account *string
 var DB *sql.DB
-var APIAccessToken string
-var MEFEcase string
+
+// blobs holds the payloads too large for SQS
+var blobs payload.BlobStore
+
+// conf holds the settings, the secrets and MEFE base URL are resolved by setup
+var conf = config.Default()
 
 func main() {
 	log.SetHandler(jsonhandler.Default)
 
-	cfg, err := external.LoadDefaultAWSConfig()
+	var err error
+	conf, err = config.Load(os.Getenv)
 	if err != nil {
-		log.WithError(err).Fatal("failed to load AWS config")
+		log.WithError(err).Fatal("failed to load config")
 	}
-
-	stssvc := sts.New(cfg)
-	input := &sts.GetCallerIdentityInput{}
-
-	req := stssvc.GetCallerIdentityRequest(input)
-	result, err := req.Send()
+	defs, err := payload.LoadActionDefinitions(conf.ActionTypes)
 	if err != nil {
-		log.WithError(err).Fatal("failed to call stssvc")
+		log.WithError(err).Fatal("failed to load ACTION_TYPES")
 	}
+	defineActions(defs)
 
-	account = result.Account
-
-	e, err := env.New(cfg)
-	if err != nil {
-		log.WithError(err).Fatal("failed to setup unee-t env")
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replay(os.Args[2:]); err != nil {
+			log.WithError(err).Fatal("replay")
+		}
+		return
 	}
+	lambda.Start(handler)
+}
 
-	DSN := fmt.Sprintf("%s:%s@tcp(%s:3306)/unee_t_enterprise?multiStatements=true&sql_mode=TRADITIONAL&timeout=5s&collation=utf8mb4_unicode_520_ci",
-		e.GetSecret("LAMBDA_INVOKER_USERNAME"),
-		e.GetSecret("LAMBDA_INVOKER_PASSWORD"),
-		e.GetSecret("UNTEDB_HOST"))
+type SQSevent struct {
+	Records []SQSrecord `json:"Records"`
+}
 
-	DB, err = sql.Open("mysql", DSN)
-	if err != nil {
-		log.WithError(err).Fatal("error opening database")
-		return
-	}
-	defer DB.Close()
+type SQSrecord struct {
+	MessageID     string `json:"messageId"`
+	ReceiptHandle string `json:"receiptHandle"`
+	Body          string `json:"body"`
+	Attributes    struct {
+		ApproximateReceiveCount          string `json:"ApproximateReceiveCount"`
+		SentTimestamp                    string `json:"SentTimestamp"`
+		SenderID                         string `json:"SenderId"`
+		ApproximateFirstReceiveTimestamp string `json:"ApproximateFirstReceiveTimestamp"`
+		// Set by FIFO queues only
+		MessageGroupID         string `json:"MessageGroupId"`
+		MessageDeduplicationID string `json:"MessageDeduplicationId"`
+	} `json:"attributes"`
+	MessageAttributes map[string]events.SQSMessageAttribute `json:"messageAttributes"`
+	Md5OfBody         string                                `json:"md5OfBody"`
+	EventSource       string                                `json:"eventSource"`
+	EventSourceARN    string                                `json:"eventSourceARN"`
+	AwsRegion         string                                `json:"awsRegion"`
+}
 
-	MEFEcase = fmt.Sprintf("https://%s", e.Udomain("case"))
-	APIAccessToken = e.GetSecret("API_ACCESS_TOKEN")
+// SQSbatchResponse lists the messages to redeliver, the rest of the batch is deleted
+// https://docs.aws.amazon.com/lambda/latest/dg/with-sqs.html#services-sqs-batchfailurereporting
+type SQSbatchResponse struct {
+	BatchItemFailures []BatchItemFailure `json:"batchItemFailures"`
+}
 
-	lambda.Start(handler)
+type BatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
 }
 
-type SQSevent struct {
-	Records []struct {
-		MessageID     string `json:"messageId"`
-		ReceiptHandle string `json:"receiptHandle"`
-		Body          string `json:"body"`
-		Attributes    struct {
-			ApproximateReceiveCount          string `json:"ApproximateReceiveCount"`
-			SentTimestamp                    string `json:"SentTimestamp"`
-			SenderID                         string `json:"SenderId"`
-			ApproximateFirstReceiveTimestamp string `json:"ApproximateFirstReceiveTimestamp"`
-		} `json:"attributes"`
-		MessageAttributes struct {
-		} `json:"messageAttributes"`
-		Md5OfBody      string `json:"md5OfBody"`
-		EventSource    string `json:"eventSource"`
-		EventSourceARN string `json:"eventSourceARN"`
-		AwsRegion      string `json:"awsRegion"`
-	} `json:"Records"`
+// batchItemFailures hands records back to SQS
+func batchItemFailures(records []SQSrecord) (out []BatchItemFailure) {
+	for _, r := range records {
+		out = append(out, BatchItemFailure{ItemIdentifier: r.MessageID})
+	}
+	return out
 }
 
-func handler(ctx context.Context, evt json.RawMessage) error {
+// recordReserve is the longest a record takes with the default retry budget
+// and MEFE_TIMEOUT: 10s of retries, a last 8s MEFE request and the reply
+// call. No record is started with less left before the Lambda timeout.
+const recordReserve = 20 * time.Second
+
+func handler(ctx context.Context, evt json.RawMessage) (*SQSbatchResponse, error) {
 
-	c := withRequestID{}
+	c := withRequestID{log: log.WithFields(log.Fields{})}
 	ctxObj, ok := lambdacontext.FromContext(ctx)
 	if ok {
 		c.log = log.WithFields(log.Fields{
@@ -104,384 +119,363 @@ func handler(ctx context.Context, evt json.RawMessage) error {
 		log.Warn("no requestID context")
 	}
 
+	if err := ready(ctx); err != nil {
+		c.log.WithError(err).Error("not ready")
+		return nil, err
+	}
+
 	var sqsMessage SQSevent
-	var dat map[string]interface{}
 
 	// Check if SQS event https://github.com/unee-t/lambda2sns/issues/21
 	err := json.Unmarshal(evt, &sqsMessage)
-	if err == nil && len(sqsMessage.Records) > 0 {
-		log.WithField("body", sqsMessage.Records[0].Body).Info("SQS interface")
-		err = json.Unmarshal([]byte(sqsMessage.Records[0].Body), &dat)
-		if err != nil {
-			return err
-		}
-	} else {
+	if err != nil || len(sqsMessage.Records) == 0 {
 		log.Info("Lambda interface")
-		err = json.Unmarshal(evt, &dat)
+		err = c.process(ctx, evt, nil)
+		if o, _ := decide(err); o == ack {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	res := &SQSbatchResponse{BatchItemFailures: []BatchItemFailure{}}
+	for i, record := range sqsMessage.Records {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < recordReserve {
+			rest := sqsMessage.Records[i:]
+			c.log.WithField("records", len(rest)).Warn("near the timeout, handing the rest back to SQS")
+			res.BatchItemFailures = append(res.BatchItemFailures, batchItemFailures(rest)...)
+			break
+		}
+		rc := withRequestID{log: c.log.WithField("messageID", record.MessageID)}
+		attrs := messageAttributes(record.MessageAttributes)
+		rc.log.WithFields(log.Fields{
+			"body":       record.Body,
+			"attributes": attrs,
+		}).Info("SQS interface")
+		err := rc.process(ctx, []byte(record.Body), attrs)
+		if rc.settle(ctx, record, err) {
+			continue
+		}
+		if record.Attributes.MessageGroupID != "" {
+			// From a FIFO queue, the records after it wait for its retry so
+			// each group stays in order
+			rest := sqsMessage.Records[i:]
+			rc.log.WithField("records", len(rest)).Warn("FIFO record failed, handing the rest back to SQS")
+			res.BatchItemFailures = append(res.BatchItemFailures, batchItemFailures(rest)...)
+			break
+		}
+		res.BatchItemFailures = append(res.BatchItemFailures, BatchItemFailure{ItemIdentifier: record.MessageID})
+	}
+	if idempotency != nil {
+		if err := idempotency.Purge(ctx); err != nil {
+			c.log.WithError(err).Warn("idempotency purge")
+		}
+	}
+	c.log.WithFields(log.Fields{
+		"records": len(sqsMessage.Records),
+		"failed":  res.BatchItemFailures,
+	}).Info("processed batch")
+	return res, nil
+}
+
+// settle acts on the outcome of processing record, false when SQS should redeliver it
+func (c withRequestID) settle(ctx context.Context, record SQSrecord, err error) bool {
+	o, perr := decide(err)
+	if perr != nil {
+		c.log = c.log.WithFields(log.Fields{"outcome": o, "kind": perr.Kind, "error": perr.Err})
+	}
+	switch o {
+	case ack:
+		if perr != nil {
+			c.log.Warn("acknowledged")
+		}
+		return true
+	case delay:
+		if requeue == nil {
+			break
+		}
+		if err := requeue.Requeue(ctx, record, perr.Delay); err != nil {
+			c.log.WithError(err).Error("requeue")
+			break
+		}
+		c.log.WithFields(log.Fields{"delay": perr.Delay, "breaker": mefeBreaker.State()}).Warn("requeued")
+	case deadLetter:
+		if requeue == nil {
+			break
+		}
+		if err := requeue.DeadLetter(ctx, record, perr); err != nil {
+			// The redrive policy gets it there eventually
+			c.log.WithError(err).Error("dead letter")
+			break
+		}
+		c.log.Error("dead-lettered")
+		return true
+	}
+	c.log.Warn("retrying")
+	return false
+}
+
+// process dispatches a single payload, attrs being its SQS message attributes
+func (c withRequestID) process(ctx context.Context, body []byte, attrs map[string]string) (err error) {
+	var dat map[string]interface{}
+	if c.dryRun = dryRun(attrs); c.dryRun {
+		c.log = c.log.WithField("dryRun", true)
+	}
+
+	// Oversized payloads were left in the blob store by push, their claim
+	// check carries the idempotency key
+	key := payload.IdempotencyKey(body)
+	cc := payload.ParseClaimCheck(body)
+	if cc != nil {
+		key = cc.IdempotencyKey
+	}
+
+	// The payload is deleted once the message is acknowledged, after its key
+	// is marked done so a duplicate no longer needs it
+	if cc != nil && blobs != nil && !c.dryRun {
+		defer func() {
+			if o, _ := decide(err); o == ack {
+				c.release(ctx, cc)
+			}
+		}()
+	}
+
+	// Redelivered and duplicated messages were already processed
+	if idempotency != nil && key != "" && !c.dryRun {
+		done, claimErr := idempotency.Claim(ctx, key)
+		if claimErr != nil {
+			c.log.WithError(claimErr).WithField("key", key).Warn("idempotency claim")
+			return claimErr
+		}
+		if done {
+			c.trace.skipped()
+			c.log.WithField("key", key).Info("already processed")
+			return nil
+		}
+		defer func() {
+			// A duplicate is acknowledged like a success, so it is done too
+			if o, _ := decide(err); o != ack {
+				err2 := idempotency.Release(ctx, key)
+				if err2 != nil {
+					c.log.WithError(err2).WithField("key", key).Error("idempotency release")
+				}
+				return
+			}
+			// The work is done, a redelivery at worst waits for the lease
+			if err := idempotency.Done(ctx, key); err != nil {
+				c.log.WithError(err).WithField("key", key).Error("idempotency done")
+			}
+		}()
+	}
+
+	if cc != nil {
+		body, err = c.claim(ctx, cc)
 		if err != nil {
 			return err
 		}
 	}
 
-	// What type of payload is this?
-	_, actionType := dat["actionType"].(string)
+	err = json.Unmarshal(body, &dat)
+	if err != nil {
+		c.log.WithError(err).Error("unable to unmarshall payload")
+		return invalid(err)
+	}
+
+	// What type of payload is this? Push tells us in the message attributes
+	actionType := attrs[payload.AttrActionType] != ""
+	if !actionType && attrs[payload.AttrNotificationType] == "" {
+		_, actionType = dat["actionType"].(string)
+	}
 	// Use dat to replace evt, since it might be parsed out of SQS
-	evt, err = json.Marshal(dat)
+	evt, err := json.Marshal(dat)
 	if err != nil {
-		return err
+		return permanent(err)
 	}
 
 	if actionType {
 		c.log.WithField("evt", evt).Info("actionType")
-		err := c.actionTypeDB(evt)
+		err := c.actionTypeDB(ctx, evt)
 		if err != nil {
 			c.log.WithError(err).Error("actionTypeDB")
 			return err
 		}
 	} else {
-		c.log.WithField("evt", evt).Info("postChangeMessage")
-		err := c.postChangeMessage(evt)
+		n, err := payload.ParseNotification(evt)
+		if err != nil {
+			// Unknown types and missing fields never reach MEFE
+			c.log.WithError(err).WithField("evt", evt).Error("invalid notification")
+			return err
+		}
+		c.log.WithFields(log.Fields{
+			"evt":      evt,
+			"invitees": n.Header().CurrentListOfInvitees,
+		}).Info("postChangeMessage")
+		err = c.postChangeMessage(ctx, evt)
 		if err != nil {
 			c.log.WithError(err).Error("postChangeMessage")
-			return nil // set to nil since we don't want lambda to retry on this type of failure
+			return err
 		}
 	}
 	c.log.WithField("evt", evt).Info("processed")
 	return nil
 }
 
-func (c withRequestID) actionTypeDB(evt json.RawMessage) (err error) {
-	// https://github.com/unee-t/lambda2sns/issues/9
+// claim fetches the payload a claim check points at
+func (c withRequestID) claim(ctx context.Context, cc *payload.ClaimCheck) ([]byte, error) {
+	if blobs == nil {
+		return nil, fmt.Errorf("claim check %s but no CLAIM_CHECK_BUCKET is configured", cc.Key)
+	}
+	body, err := blobs.Get(ctx, cc.Key)
+	if err != nil {
+		c.log.WithError(err).WithField("claimCheck", cc).Error("failed to fetch payload")
+		return nil, err
+	}
+	c.log.WithField("claimCheck", cc).Info("fetched payload")
+	return body, nil
+}
 
-	type actionType struct {
-		UnitCreationRequestID       int    `json:"unitCreationRequestId,omitempty"`
-		UserCreationRequestID       int    `json:"userCreationRequestId,omitempty"`
-		IDmapUserUnitPermissions    int    `json:"idMapUserUnitPermission,omitempty"`
-		MEFERequestID               string `json:"mefeAPIRequestId,omitempty"`
-		UpdateUserRequestID         int    `json:"updateUserRequestId,omitempty"`
-		UpdateUnitRequestID         int    `json:"updateUnitRequestId,omitempty"`
-		RemoveUserFromUnitRequestID int    `json:"removeUserFromUnitRequestId,omitempty"`
-		Type                        string `json:"actionType"`
+// release deletes a claimed payload once its message is done with
+func (c withRequestID) release(ctx context.Context, cc *payload.ClaimCheck) {
+	err := blobs.Delete(ctx, cc.Key)
+	if err != nil {
+		c.log.WithError(err).WithField("claimCheck", cc).Warn("failed to delete payload")
 	}
+}
 
-	var act actionType
+// messageAttributes flattens the string and number attributes set by push
+func messageAttributes(in map[string]events.SQSMessageAttribute) map[string]string {
+	out := map[string]string{}
+	for key, attr := range in {
+		if attr.StringValue != nil {
+			out[key] = *attr.StringValue
+		}
+	}
+	return out
+}
 
-	// log.WithField("evt", evt).Info("in actiontypeDB")
+func (c withRequestID) actionTypeDB(reqCtx context.Context, evt json.RawMessage) (err error) {
+	// https://github.com/unee-t/lambda2sns/issues/9
 
+	var act payload.ActionType
 	if err := json.Unmarshal(evt, &act); err != nil {
-		c.log.WithError(err).Fatal("unable to unmarshall payload")
-		return err
+		c.log.WithError(err).Error("unable to unmarshall payload")
+		return &payload.ValidationError{Reason: fmt.Sprintf("unable to unmarshall payload: %v", err)}
 	}
-
 	ctx := c.log.WithField("actionType", act)
-	if act.MEFERequestID == "" {
-		ctx.Error("missing mefeAPIRequestId")
-		return fmt.Errorf("missing mefeAPIRequestId")
-	}
-	switch act.Type {
-	case "CREATE_UNIT":
-		if act.UnitCreationRequestID == 0 {
-			ctx.Error("missing unitCreationRequestId")
-			return fmt.Errorf("missing unitCreationRequestId")
-		}
-	case "EDIT_USER":
-		if act.UpdateUserRequestID == 0 {
-			ctx.Error("missing updateUserRequestId")
-			return fmt.Errorf("missing updateUserRequestId")
-		}
-	case "EDIT_UNIT":
-		if act.UpdateUnitRequestID == 0 {
-			ctx.Error("missing updateUnitRequestId")
-			return fmt.Errorf("missing updateUnitRequestId")
-		}
-	case "CREATE_USER":
-		if act.UserCreationRequestID == 0 {
-			ctx.Error("missing userCreationRequestId")
-			return fmt.Errorf("missing userCreationRequestId")
-		}
-	case "ASSIGN_ROLE":
-		if act.IDmapUserUnitPermissions == 0 {
-			ctx.Error("missing idMapUserUnitPermission")
-			return fmt.Errorf("missing idMapUserUnitPermission")
-		}
-	case "DEASSIGN_ROLE":
-		if act.RemoveUserFromUnitRequestID == 0 {
-			ctx.Error("missing removeUserFromUnitRequestId")
-			return fmt.Errorf("missing removeUserFromUnitRequestId")
-		}
-	default:
-		ctx.Error("unknown type")
-		return fmt.Errorf("unknown type: %s", act.Type)
-	}
 
-	if APIAccessToken == "" {
-		ctx.Error("missing API_ACCESS_TOKEN credential")
-		return fmt.Errorf("missing API_ACCESS_TOKEN credential")
+	h, ok := actions[act.Type]
+	if !ok {
+		ctx.Error("unknown type")
+		return &payload.ValidationError{ActionType: act.Type, Reason: "unknown type"}
 	}
-
-	url := MEFEcase + "/api/process-api-payload?accessToken=" + APIAccessToken
-	c.log.Debugf("Posting to: %s, payload %s", url, evt)
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(evt)))
-	if err != nil {
-		c.log.WithError(err).Error("constructing POST")
+	if err := h.Validate(evt); err != nil {
+		ctx.WithError(err).Error("invalid payload")
 		return err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+APIAccessToken)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		c.log.WithError(err).Error("POST request")
-		return err
+	if c.dryRun {
+		return c.dryRunAction(h, evt)
 	}
-	defer res.Body.Close()
-	resBody, err := ioutil.ReadAll(res.Body)
+
+	c.log.Debugf("Posting payload %s", evt)
+	status, resBody, err := c.post(reqCtx, h.RetryPolicy(), func() (*http.Request, error) {
+		return h.Request(evt)
+	})
 	if err != nil {
-		c.log.WithError(err).Error("failed to read body")
+		c.log.WithError(err).WithField("breaker", mefeBreaker.State()).Error("POST request")
 		return err
 	}
+	c.trace.mefe(status)
 
-	var errorMessage string
-
-	var isCreatedByMe int
-	// https://github.com/unee-t/lambda2sns/issues/9#issuecomment-474238691
-	switch res.StatusCode {
-	case http.StatusOK:
-		isCreatedByMe = 0
-	case http.StatusCreated:
-		isCreatedByMe = 1
-	default:
-		ctx.WithFields(log.Fields{
-			"status":   res.StatusCode,
-			"evt":      evt,
-			"response": string(resBody),
-		}).Error("MEFE process-api-payload")
-		// We don't stop here since we want to feedback errors to db
-		errorMessage = escape(fmt.Sprintf("Error: %s from MEFE: %s, Response: %s from Request: %s", res.Status, url, string(resBody), string(evt)))
-	}
-
-	type creationResponse struct {
-		ID         string    `json:"id"`
-		UnitID     string    `json:"unitMongoId"`
-		UserID     string    `json:"userId"`
-		Timestamp  time.Time `json:"timestamp"`
-		MefeAPIkey string    `json:"mefeApiKey"`
+	reply, err := h.Interpret(evt, status, resBody)
+	if err != nil {
+		c.log.WithError(err).Error("unable to unmarshall response")
+		return permanent(err)
 	}
-
-	var parsedResponse creationResponse
-
-	if errorMessage != "" {
-		// parsedResponse.ID = fmt.Sprintf("error-%s-%d", act.Type, time.Now().UnixNano())
+	if reply.ErrorMessage != "" {
 		ctx = ctx.WithFields(log.Fields{
-			"errorMessage": errorMessage,
+			"status":       status,
+			"evt":          evt,
+			"response":     string(resBody),
+			"errorMessage": reply.ErrorMessage,
 		})
-	} else {
-		if err := json.Unmarshal(resBody, &parsedResponse); err != nil {
-			c.log.WithError(err).Fatal("unable to unmarshall payload")
-			return err
-		}
-
-		switch act.Type {
-		case "CREATE_UNIT":
-			parsedResponse.ID = parsedResponse.UnitID
-		case "CREATE_USER":
-			parsedResponse.ID = parsedResponse.UserID
-		}
+		ctx.Error("MEFE process-api-payload")
 	}
 
+	c.trace.reply(reply.ID)
 	ctx = ctx.WithFields(log.Fields{
-		"id":               parsedResponse.ID,
-		"timestamp":        parsedResponse.Timestamp,
-		"is_created_by_me": isCreatedByMe,
+		"id":               reply.ID,
+		"timestamp":        reply.Timestamp,
+		"is_created_by_me": reply.IsCreatedByMe,
 	})
 
-	// https://dev.mysql.com/doc/refman/8.0/en/datetime.html
-	sqlTimeLayout := "2006-01-02 15:04:05"
-
-	var filledSQL string
-	switch act.Type {
-	case "CREATE_UNIT":
-		templateSQL := `SET @unit_creation_request_id = %d;
-SET @mefe_unit_id = '%s';
-SET @creation_datetime = '%s';
-SET @is_created_by_me = %d;
-SET @mefe_api_error_message = '%s';
-SET @mefe_api_request_id  = '%s';
-CALL ut_creation_unit_mefe_api_reply;`
-		filledSQL = fmt.Sprintf(templateSQL,
-			act.UnitCreationRequestID,
-			parsedResponse.ID,
-			parsedResponse.Timestamp.Format(sqlTimeLayout),
-			isCreatedByMe,
-			errorMessage,
-			act.MEFERequestID,
-		)
-	case "CREATE_USER":
-		templateSQL := `SET @user_creation_request_id = %d;
-SET @mefe_user_id = '%s';
-SET @creation_datetime = '%s';
-SET @is_created_by_me = %d;
-SET @mefe_api_error_message = '%s';
-SET @mefe_user_api_key = '%s';
-SET @mefe_api_request_id  = '%s';
-CALL ut_creation_user_mefe_api_reply;`
-		filledSQL = fmt.Sprintf(templateSQL,
-			act.UserCreationRequestID,
-			parsedResponse.ID,
-			parsedResponse.Timestamp.Format(sqlTimeLayout),
-			isCreatedByMe,
-			errorMessage,
-			parsedResponse.MefeAPIkey,
-			act.MEFERequestID,
-		)
-	case "ASSIGN_ROLE":
-		templateSQL := `SET @id_map_user_unit_permissions = %d;
-SET @creation_datetime = '%s';
-SET @mefe_api_error_message = '%s';
-SET @mefe_api_request_id  = '%s';
-CALL ut_creation_user_role_association_mefe_api_reply;`
-		filledSQL = fmt.Sprintf(templateSQL, act.IDmapUserUnitPermissions, parsedResponse.Timestamp.Format(sqlTimeLayout), errorMessage, act.MEFERequestID)
-	case "EDIT_USER":
-		templateSQL := `SET @update_user_request_id = %d;
-SET @updated_datetime = '%s';
-SET @mefe_api_error_message = '%s';
-SET @mefe_api_request_id  = '%s';
-CALL ut_update_user_mefe_api_reply;`
-		filledSQL = fmt.Sprintf(templateSQL, act.UpdateUserRequestID, parsedResponse.Timestamp.Format(sqlTimeLayout), errorMessage, act.MEFERequestID)
-	case "EDIT_UNIT":
-		templateSQL := `SET @update_unit_request_id = %d;
-SET @updated_datetime = '%s';
-SET @mefe_api_error_message = '%s';
-SET @mefe_api_request_id  = '%s';
-CALL ut_update_unit_mefe_api_reply;`
-		filledSQL = fmt.Sprintf(templateSQL, act.UpdateUnitRequestID, parsedResponse.Timestamp.Format(sqlTimeLayout), errorMessage, act.MEFERequestID)
-	case "DEASSIGN_ROLE":
-		templateSQL := `SET @remove_user_from_unit_request_id = %d;
-SET @updated_datetime = '%s';
-SET @mefe_api_error_message = '%s';
-SET @mefe_api_request_id  = '%s';
-CALL ut_remove_user_role_association_mefe_api_reply;`
-		filledSQL = fmt.Sprintf(templateSQL, act.RemoveUserFromUnitRequestID, parsedResponse.Timestamp.Format(sqlTimeLayout), errorMessage, act.MEFERequestID)
-	default:
-		return fmt.Errorf("Unknown type: %s, so no SQL template can be inferred", act.Type)
-	}
-	_, err = DB.Exec(filledSQL)
+	call, err := h.Persist(reqCtx, DB, evt, reply)
+	c.trace.sql(call, err)
 	if err != nil {
 		if strings.Contains(err.Error(), "Error 1062") {
 			// https://github.com/unee-t/lambda2sns/issues/20
-			ctx.WithError(err).WithField("sql", filledSQL).Warn("Duplicate entry")
-			return nil
+			ctx.WithError(err).WithField("sql", call.String()).Warn("Duplicate entry")
+			return duplicate(err)
 		}
-		ctx.WithError(err).WithField("sql", filledSQL).Error("running sql failed")
-		// automatically retry the invocation twice, with delays between retries
-		// https://docs.aws.amazon.com/lambda/latest/dg/retries-on-errors.html
-		return err
+		ctx.WithError(err).WithField("sql", call.String()).Error("running sql failed")
+		return transient(err, 0)
 	}
 
 	c.log.WithFields(log.Fields{
-		"stats":     DB.Stats(),
-		"filledSQL": filledSQL,
+		"stats": DB.Stats(),
+		"sql":   call.String(),
 	}).Info("ran SQL without error")
 
-	if errorMessage != "" && res.StatusCode >= 500 {
+	if reply.ErrorMessage != "" && status >= 500 {
 		// Payload is valid, but the action took took long (POST time out, database time out)
-		return errors.New(errorMessage)
+		return transient(errors.New(reply.ErrorMessage), 0)
 	}
-	if errorMessage != "" {
+	if reply.ErrorMessage != "" {
 		// Assuming Payload is wrong
-		ctx.WithField("status", res.StatusCode).Warn("not returning an error for triggering a retry")
+		ctx.WithField("status", status).Warn("not returning an error for triggering a retry")
 	}
 	return err
 }
 
 // For event notifications https://github.com/unee-t/lambda2sns/tree/master/tests/events
-func (c withRequestID) postChangeMessage(evt json.RawMessage) (err error) {
-	url := MEFEcase + "/api/db-change-message/process?accessToken=" + APIAccessToken
-	c.log.Infof("Posting to: %s, payload %s", url, evt)
+func (c withRequestID) postChangeMessage(ctx context.Context, evt json.RawMessage) (err error) {
+	token := string(conf.Process.MEFE.AccessToken)
+	url := conf.Process.MEFE.BaseURL + "/api/db-change-message/process?accessToken=" + token
+	c.log.Infof("Posting to: %s, payload %s", redact(url), evt)
 
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(evt)))
-	if err != nil {
-		c.log.WithError(err).Error("constructing POST")
-		return err
+	build := func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, strings.NewReader(string(evt)))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", "Bearer "+token)
+		return req, nil
 	}
-
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+APIAccessToken)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		c.log.WithError(err).Error("POST request")
-		return err
+	if c.dryRun {
+		return c.dryRunRequest(build)
 	}
-	defer res.Body.Close()
-	resBody, err := ioutil.ReadAll(res.Body)
+
+	status, resBody, err := c.post(ctx, defaultRetry, build)
 	if err != nil {
-		c.log.WithError(err).Error("failed to read body")
+		c.log.WithError(err).WithField("breaker", mefeBreaker.State()).Error("POST request")
 		return err
 	}
-	if res.StatusCode == http.StatusOK {
+	c.trace.mefe(status)
+	if status == http.StatusOK {
 		c.log.WithFields(log.Fields{
-			"status":   res.StatusCode,
+			"status":   status,
 			"response": string(resBody),
 		}).Info("OK")
 	} else {
 		c.log.WithFields(log.Fields{
-			"status":   res.StatusCode,
+			"status":   status,
 			"response": string(resBody),
 		}).Error("MEFE db-change-message/process")
-		return fmt.Errorf("/api/db-change-message/process response code %d, Request: %s Response: %s", res.StatusCode, evt, string(resBody))
-	}
-	return err
-}
-
-// from https://github.com/golang/go/issues/18478#issuecomment-357285669
-func escape(source string) string {
-	var j int
-	if len(source) == 0 {
-		return ""
-	}
-	tempStr := source[:]
-	desc := make([]byte, len(tempStr)*2)
-	for i := 0; i < len(tempStr); i++ {
-		flag := false
-		var escape byte
-		switch tempStr[i] {
-		case '\r':
-			flag = true
-			escape = '\r'
-			break
-		case '\n':
-			flag = true
-			escape = '\n'
-			break
-		case '\\':
-			flag = true
-			escape = '\\'
-			break
-		case '\'':
-			flag = true
-			escape = '\''
-			break
-		case '"':
-			flag = true
-			escape = '"'
-			break
-		case '\032':
-			flag = true
-			escape = 'Z'
-			break
-		default:
-		}
-		if flag {
-			desc[j] = '\\'
-			desc[j+1] = escape
-			j = j + 2
-		} else {
-			desc[j] = tempStr[i]
-			j = j + 1
+		err = fmt.Errorf("/api/db-change-message/process response code %d, Request: %s Response: %s", status, evt, string(resBody))
+		if status >= 500 {
+			return transient(err, 0)
 		}
+		return permanent(err)
 	}
-	return string(desc[0:j])
+	return err
 }
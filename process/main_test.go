@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The tests stand in for AWS, MEFE and the DB themselves
+	setup = &initializer{fn: func(context.Context) error { return nil }}
+	os.Exit(m.Run())
+}
+
+// mefe stands in for the MEFE API, answering with status
+func mefe(status int) (received *[]string, close func()) {
+	received = &[]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		*received = append(*received, r.URL.Path+" "+string(body))
+		w.WriteHeader(status)
+	}))
+	old := conf.Process.MEFE
+	conf.Process.MEFE.BaseURL, conf.Process.MEFE.AccessToken = srv.URL, "secret"
+	return received, func() {
+		srv.Close()
+		conf.Process.MEFE = old
+	}
+}
+
+// useMEFE points process at the MEFE stand-in url for the test
+func useMEFE(t *testing.T, url string) {
+	old := conf.Process.MEFE
+	conf.Process.MEFE.BaseURL, conf.Process.MEFE.AccessToken = url, "secret"
+	t.Cleanup(func() { conf.Process.MEFE = old })
+}
+
+func sqsEvent(bodies ...string) json.RawMessage {
+	var evt SQSevent
+	for i, body := range bodies {
+		evt.Records = append(evt.Records, SQSrecord{MessageID: fmt.Sprintf("m%d", i), Body: body})
+	}
+	out, _ := json.Marshal(evt)
+	return out
+}
+
+const caseNewMessage = `{"notification_type": "case_new_message", "bz_source_table": "ut_notification_message_new", "notification_id": "ut_notification_message_new-4300", "unit_id": "2203", "case_id": 3293, "created_by_user_id": "6017"}`
+
+func Test_handlerBatch(t *testing.T) {
+	resetBreaker(t)
+	received, close := mefe(http.StatusOK)
+	defer close()
+
+	res, err := handler(context.Background(), sqsEvent(
+		caseNewMessage,
+		`not JSON`,
+		`{"actionType": "CREATE_UNIT", "mefeAPIRequestId": "e7bb7494"}`,
+		caseNewMessage,
+		`{"notification_type": "case_deleted", "notification_id": "ut_notification_case_deleted-1"}`,
+		`{"notification_type": "case_user_invited", "notification_id": "ut_notification_case_invited-87", "unit_id": "2203", "case_id": "3293"}`,
+	))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if fmt.Sprint(res.BatchItemFailures) != "[{m1} {m2} {m4} {m5}]" {
+		t.Errorf("handler() batchItemFailures = %v, want m1, m2, m4 and m5", res.BatchItemFailures)
+	}
+	if len(*received) != 2 {
+		t.Errorf("MEFE received %d notifications, want 2", len(*received))
+	}
+}
+
+func Test_handlerDeadline(t *testing.T) {
+	resetBreaker(t)
+	received, close := mefe(http.StatusOK)
+	defer close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), recordReserve-time.Second)
+	defer cancel()
+	res, err := handler(ctx, sqsEvent(caseNewMessage, caseNewMessage))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if fmt.Sprint(res.BatchItemFailures) != "[{m0} {m1}]" || len(*received) != 0 {
+		t.Errorf("handler() batchItemFailures = %v after %d notifications, want both untouched", res.BatchItemFailures, len(*received))
+	}
+}
+
+func Test_handlerFIFO(t *testing.T) {
+	resetBreaker(t)
+	received, close := mefe(http.StatusOK)
+	defer close()
+
+	var evt SQSevent
+	for i, body := range []string{caseNewMessage, `not JSON`, caseNewMessage, caseNewMessage} {
+		r := SQSrecord{MessageID: fmt.Sprintf("m%d", i), Body: body}
+		r.Attributes.MessageGroupID = "case-3293"
+		evt.Records = append(evt.Records, r)
+	}
+	raw, _ := json.Marshal(evt)
+	res, err := handler(context.Background(), raw)
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if fmt.Sprint(res.BatchItemFailures) != "[{m1} {m2} {m3}]" || len(*received) != 1 {
+		t.Errorf("handler() batchItemFailures = %v after %d notifications, want m1 and the records after it", res.BatchItemFailures, len(*received))
+	}
+}
+
+func Test_handlerLambda(t *testing.T) {
+	_, close := mefe(http.StatusOK)
+	defer close()
+
+	res, err := handler(context.Background(), json.RawMessage(caseNewMessage))
+	if err != nil || res != nil {
+		t.Errorf("handler() = %v, %v", res, err)
+	}
+}
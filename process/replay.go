@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/text"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// trace records what dispatching a payload did, for the replay report.
+// Its methods do nothing on a nil trace.
+type trace struct {
+	Status    int
+	ID        string
+	Procedure string
+	SQL       string
+	Skipped   bool
+	// Request & Call are what a dry run would have sent and run
+	Request string
+	Call    string
+}
+
+func (t *trace) mefe(status int) {
+	if t != nil {
+		t.Status = status
+	}
+}
+
+func (t *trace) reply(id string) {
+	if t != nil {
+		t.ID = id
+	}
+}
+
+func (t *trace) sql(call replyCall, err error) {
+	if t == nil {
+		return
+	}
+	t.Procedure = call.Procedure
+	switch {
+	case err == nil:
+		t.SQL = "ok"
+	case strings.Contains(err.Error(), "Error 1062"):
+		t.SQL = "duplicate"
+	default:
+		t.SQL = "failed: " + err.Error()
+	}
+}
+
+func (t *trace) dryRun(request, call string) {
+	if t != nil {
+		t.Request, t.Call = request, call
+	}
+}
+
+func (t *trace) skipped() {
+	if t != nil {
+		t.Skipped = true
+	}
+}
+
+// replayInput is a recorded payload and where it was read from
+type replayInput struct {
+	Source  string
+	Payload json.RawMessage
+}
+
+// replayResult is the report line of a replayed payload
+type replayResult struct {
+	Source  string   `json:"source"`
+	Decoded []string `json:"decoded,omitempty"`
+	// MEFEStatus is the HTTP status of the last MEFE answer
+	MEFEStatus int    `json:"mefeStatus,omitempty"`
+	ID         string `json:"id,omitempty"`
+	Procedure  string `json:"procedure,omitempty"`
+	// SQL is ok, duplicate or why the reply procedure failed
+	SQL              string `json:"sql,omitempty"`
+	AlreadyProcessed bool   `json:"alreadyProcessed,omitempty"`
+	// DryRunRequest & DryRunCall are set instead under DRY_RUN
+	DryRunRequest string  `json:"dryRunRequest,omitempty"`
+	DryRunCall    string  `json:"dryRunCall,omitempty"`
+	Outcome       outcome `json:"outcome"`
+	Kind          string  `json:"kind,omitempty"`
+	Error         string  `json:"error,omitempty"`
+	Took          string  `json:"took"`
+}
+
+// rejected payloads would not get past push
+const rejected outcome = "rejected"
+
+// replay feeds recorded payloads through the push digest and the process
+// dispatch, against the configured MEFE and DB:
+//
+//	process replay -rate 2 tests/events payloads.jsonl
+//
+// A file holds a payload per line, a directory a payload per .json file. A
+// report line per payload goes to stdout.
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	rate := fs.Float64("rate", 0, "payloads per second, 0 for no limit")
+	stop := fs.Bool("stop-on-error", false, "stop at the first payload not acknowledged")
+	fs.Parse(args)
+
+	log.SetHandler(text.New(os.Stderr))
+	if fs.NArg() == 0 {
+		return fmt.Errorf("nothing to replay, give JSONL files or directories of JSON files")
+	}
+	inputs, err := readInputs(fs.Args())
+	if err != nil {
+		return err
+	}
+	spec, err := payload.LoadDecodingSpec(conf.Push.DecodingSpec)
+	if err != nil {
+		return fmt.Errorf("DECODING_SPEC: %w", err)
+	}
+	ctx := context.Background()
+	if err := ready(ctx); err != nil {
+		return err
+	}
+	results, err := replayAll(ctx, inputs, replayer{spec: spec, strict: conf.Push.DecodingStrict}, *rate, *stop, os.Stdout)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Outcome != ack {
+			failed++
+		}
+	}
+	log.WithFields(log.Fields{"replayed": len(results), "failed": failed, "of": len(inputs)}).Info("replay")
+	if failed > 0 {
+		return fmt.Errorf("%d of %d payloads failed", failed, len(results))
+	}
+	return nil
+}
+
+// readInputs reads the payloads of JSONL files and directories of JSON files, - being stdin
+func readInputs(paths []string) (inputs []replayInput, err error) {
+	for _, path := range paths {
+		if path == "-" {
+			in, err := readLines("stdin", os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, in...)
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			in, err := readLines(path, f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, in...)
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			body, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, replayInput{Source: file, Payload: body})
+		}
+	}
+	return inputs, nil
+}
+
+// readLines reads a payload per non-blank line, named source:line
+func readLines(source string, r io.Reader) (inputs []replayInput, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, payload.MaxMessageSize*4)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		inputs = append(inputs, replayInput{Source: fmt.Sprintf("%s:%d", source, n), Payload: json.RawMessage(line)})
+	}
+	return inputs, s.Err()
+}
+
+// replayer replays a payload the way push and process would handle it
+type replayer struct {
+	spec   payload.DecodingSpec
+	strict bool
+}
+
+func (r replayer) replay(ctx context.Context, in replayInput) (res replayResult) {
+	start := time.Now()
+	res.Source = in.Source
+	defer func() { res.Took = time.Since(start).String() }()
+
+	evt, decoded, err := payload.Digest(in.Payload, r.spec, r.strict)
+	res.Decoded = decoded
+	if err == nil {
+		// Push rejects these before they are enqueued
+		err = payload.Validate(evt)
+	}
+	if err != nil {
+		res.Outcome, res.Error = rejected, err.Error()
+		return res
+	}
+
+	t := &trace{}
+	c := withRequestID{log: log.WithField("source", in.Source), trace: t}
+	err = c.process(ctx, evt, payload.Attributes(evt))
+	o, perr := decide(err)
+	res.Outcome = o
+	if perr != nil {
+		res.Kind, res.Error = perr.Kind.String(), perr.Err.Error()
+	}
+	res.MEFEStatus, res.ID, res.Procedure, res.SQL, res.AlreadyProcessed = t.Status, t.ID, t.Procedure, t.SQL, t.Skipped
+	res.DryRunRequest, res.DryRunCall = t.Request, t.Call
+	return res
+}
+
+// replayAll replays inputs at most rate per second, writing a report line
+// per payload to w, until one fails when stop is set
+func replayAll(ctx context.Context, inputs []replayInput, r replayer, rate float64, stop bool, w io.Writer) (results []replayResult, err error) {
+	var tick <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	enc := json.NewEncoder(w)
+	for i, in := range inputs {
+		if tick != nil && i > 0 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return results, ctx.Err()
+			}
+		}
+		res := r.replay(ctx, in)
+		results = append(results, res)
+		if err := enc.Encode(res); err != nil {
+			return results, err
+		}
+		if stop && res.Outcome != ack {
+			log.WithField("source", in.Source).Warn("stopping on error")
+			break
+		}
+	}
+	return results, nil
+}
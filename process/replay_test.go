@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+func Test_readInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jsonl := filepath.Join(dir, "payloads.jsonl")
+	ioutil.WriteFile(jsonl, []byte("{\"a\": 1}\n\n{\"b\": 2}\n"), 0644)
+
+	inputs, err := readInputs([]string{jsonl, "../tests/events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 11 {
+		t.Fatalf("read %d payloads, want 2 lines and 9 events", len(inputs))
+	}
+	if inputs[1].Source != jsonl+":3" || string(inputs[1].Payload) != `{"b": 2}` {
+		t.Errorf("second line = %+v", inputs[1])
+	}
+	if inputs[2].Source != filepath.Join("../tests/events", "assign_role.json") {
+		t.Errorf("first event = %s", inputs[2].Source)
+	}
+}
+
+func Test_replayAll(t *testing.T) {
+	resetBreaker(t)
+	noSleep(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/db-change-message/process" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"unitMongoId": "jAPsg5sZBjSDT9QSD", "timestamp": "2019-08-23T07:54:20Z"}`))
+	}))
+	defer srv.Close()
+	useMEFE(t, srv.URL)
+	var r *recorder
+	DB, r = openRecorder(t)
+
+	inputs := []replayInput{
+		// name is base64 encoded, the push digest decodes it
+		{"create", []byte(`{"actionType": "CREATE_UNIT", "mefeAPIRequestId": "e7bb7494", "unitCreationRequestId": 4771, "name": "Sm/FvmtvIE1ya3ZpxI1rw6EgMQ=="}`)},
+		{"notification", []byte(caseNewMessage)},
+		{"invalid", []byte(`{"actionType": "CREATE_UNIT"}`)},
+		{"after", []byte(caseNewMessage)},
+	}
+	var out bytes.Buffer
+	results, err := replayAll(context.Background(), inputs, replayer{spec: payload.DefaultDecodingSpec}, 0, true, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("replayed %d payloads, want to stop after the invalid one", len(results))
+	}
+
+	create := results[0]
+	if create.Outcome != ack || create.MEFEStatus != http.StatusCreated || create.ID != "jAPsg5sZBjSDT9QSD" ||
+		create.Procedure != "ut_creation_unit_mefe_api_reply" || create.SQL != "ok" {
+		t.Errorf("create = %+v", create)
+	}
+	if n := results[1]; n.Outcome != ack || n.MEFEStatus != http.StatusOK || n.SQL != "" {
+		t.Errorf("notification = %+v", n)
+	}
+	if n := results[2]; n.Outcome != rejected || n.Error == "" {
+		t.Errorf("invalid = %+v", n)
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != 3 {
+		t.Errorf("report has %d lines, want 3", lines)
+	}
+	if len(create.Decoded) != 1 || create.Decoded[0] != "name" || len(r.Statements()) == 0 {
+		t.Errorf("decoded %v, ran %v", create.Decoded, r.Statements())
+	}
+
+	t.Run("SQL failure", func(t *testing.T) {
+		r.fail = "CALL"
+		results, _ := replayAll(context.Background(), inputs[:1], replayer{spec: payload.DefaultDecodingSpec}, 0, false, ioutil.Discard)
+		if res := results[0]; res.Outcome != retry || res.SQL == "ok" || res.Kind != "transient" {
+			t.Errorf("create = %+v", res)
+		}
+	})
+}
+
+func Test_replayAllRate(t *testing.T) {
+	inputs := []replayInput{{"a", []byte(`{}`)}, {"b", []byte(`{}`)}, {"c", []byte(`{}`)}}
+	start := time.Now()
+	results, err := replayAll(context.Background(), inputs, replayer{}, 50, false, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Errorf("replayed %d payloads, want 3 without stop-on-error", len(results))
+	}
+	if took := time.Since(start); took < 40*time.Millisecond {
+		t.Errorf("3 payloads at 50/s took %v", took)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// https://dev.mysql.com/doc/refman/8.0/en/datetime.html
+const sqlTimeLayout = "2006-01-02 15:04:05"
+
+// replyCall is a *_mefe_api_reply stored procedure call. The procedures read
+// their arguments from session variables, so they are bound as parameters
+// and set on the same connection as the CALL.
+type replyCall struct {
+	Procedure string
+	Vars      []string
+	Args      []interface{}
+}
+
+// set assigns values to the session variables
+func (r replyCall) set(ctx context.Context, tx *sql.Tx, values []interface{}) error {
+	assignments := make([]string, len(r.Vars))
+	for i, v := range r.Vars {
+		assignments[i] = fmt.Sprintf("@%s = ?", v)
+	}
+	_, err := tx.ExecContext(ctx, "SET "+strings.Join(assignments, ", "), values...)
+	return err
+}
+
+// exec runs the call in a transaction, clearing the session variables before
+// the connection goes back to the pool, whether the call failed or not: they
+// outlive a rollback
+func (r replyCall) exec(ctx context.Context, db *sql.DB) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := r.set(ctx, tx, make([]interface{}, len(r.Vars))); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+	if err = r.set(ctx, tx, r.Args); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "CALL "+r.Procedure)
+	return err
+}
+
+// String shows the call with its bound parameters, for logs
+func (r replyCall) String() string {
+	assignments := make([]string, len(r.Vars))
+	for i, v := range r.Vars {
+		assignments[i] = fmt.Sprintf("@%s = %#v", v, r.Args[i])
+	}
+	return fmt.Sprintf("SET %s; CALL %s;", strings.Join(assignments, ", "), r.Procedure)
+}
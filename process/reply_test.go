@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is a database/sql driver that records the statements it runs
+type recorder struct {
+	mu    sync.Mutex
+	stmts []string
+	fail  string // fail statements containing fail
+	code  int    // with this MySQL error, 1644 by default
+	// answer gives the rows affected by, or the single value selected by, a statement
+	answer func(query string) (affected int64, value driver.Value)
+}
+
+func (r *recorder) Open(name string) (driver.Conn, error) { return &recorderConn{r}, nil }
+
+func (r *recorder) record(query string, args []driver.Value) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stmts = append(r.stmts, fmt.Sprint(query, args))
+	if r.fail != "" && strings.Contains(query, r.fail) {
+		code := r.code
+		if code == 0 {
+			code = 1644
+		}
+		return fmt.Errorf("Error %d: %s failed", code, r.fail)
+	}
+	return nil
+}
+
+func (r *recorder) Statements() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.stmts...)
+}
+
+type recorderConn struct{ r *recorder }
+
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return &recorderStmt{c.r, query}, nil
+}
+func (c *recorderConn) Close() error { return nil }
+func (c *recorderConn) Begin() (driver.Tx, error) {
+	return c, c.r.record("BEGIN", nil)
+}
+func (c *recorderConn) Commit() error   { return c.r.record("COMMIT", nil) }
+func (c *recorderConn) Rollback() error { return c.r.record("ROLLBACK", nil) }
+
+type recorderStmt struct {
+	r     *recorder
+	query string
+}
+
+func (s *recorderStmt) Close() error  { return nil }
+func (s *recorderStmt) NumInput() int { return -1 }
+func (s *recorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.r.record(s.query, args); err != nil || s.r.answer == nil {
+		return driver.RowsAffected(0), err
+	}
+	affected, _ := s.r.answer(s.query)
+	return driver.RowsAffected(affected), nil
+}
+func (s *recorderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := s.r.record(s.query, args); err != nil {
+		return nil, err
+	}
+	rows := &recorderRows{}
+	if s.r.answer != nil {
+		if _, v := s.r.answer(s.query); v != nil {
+			rows.values = []driver.Value{v}
+		}
+	}
+	return rows, nil
+}
+
+// recorderRows is a single column result
+type recorderRows struct {
+	values []driver.Value
+}
+
+func (r *recorderRows) Columns() []string { return []string{"value"} }
+func (r *recorderRows) Close() error      { return nil }
+func (r *recorderRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+var recorders = 0
+
+// openRecorder returns a DB backed by a new recorder
+func openRecorder(t *testing.T) (*sql.DB, *recorder) {
+	r := &recorder{}
+	recorders++
+	name := fmt.Sprintf("recorder%d", recorders)
+	sql.Register(name, r)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, r
+}
+
+func Test_replyCall(t *testing.T) {
+	call := replyCall{
+		Procedure: "ut_update_unit_mefe_api_reply",
+		Vars:      []string{"update_unit_request_id", "updated_datetime", "mefe_api_error_message", "mefe_api_request_id"},
+		Args:      []interface{}{1054, "2019-03-05 04:13:20", "it's broken", "e7bb7494"},
+	}
+	want := `SET @update_unit_request_id = 1054, @updated_datetime = "2019-03-05 04:13:20", @mefe_api_error_message = "it's broken", @mefe_api_request_id = "e7bb7494"; CALL ut_update_unit_mefe_api_reply;`
+	if call.String() != want {
+		t.Errorf("String() = %s, want %s", call.String(), want)
+	}
+
+	db, r := openRecorder(t)
+	if err := call.exec(context.Background(), db); err != nil {
+		t.Fatalf("exec() error = %v", err)
+	}
+	got := strings.Join(r.Statements(), "\n")
+	wantClear := "SET @update_unit_request_id = ?, @updated_datetime = ?, @mefe_api_error_message = ?, @mefe_api_request_id = ?[<nil> <nil> <nil> <nil>]"
+	wantStmts := strings.Join([]string{
+		"BEGIN[]",
+		"SET @update_unit_request_id = ?, @updated_datetime = ?, @mefe_api_error_message = ?, @mefe_api_request_id = ?[1054 2019-03-05 04:13:20 it's broken e7bb7494]",
+		"CALL ut_update_unit_mefe_api_reply[]",
+		wantClear,
+		"COMMIT[]",
+	}, "\n")
+	if got != wantStmts {
+		t.Errorf("exec() ran\n%s\nwant\n%s", got, wantStmts)
+	}
+
+	db, r = openRecorder(t)
+	r.fail = "CALL"
+	if err := call.exec(context.Background(), db); err == nil {
+		t.Error("exec() wants the CALL error")
+	}
+	stmts := r.Statements()
+	if len(stmts) != 5 || stmts[3] != wantClear || stmts[4] != "ROLLBACK[]" {
+		t.Errorf("exec() ran %v, want the variables cleared and a ROLLBACK", stmts)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// requeuer hands a failed message back to its queue, visible again after
+// delay, or moves it to the dead letter queue
+type requeuer interface {
+	Requeue(ctx context.Context, record SQSrecord, delay time.Duration) error
+	DeadLetter(ctx context.Context, record SQSrecord, reason error) error
+}
+
+// requeue is nil outside Lambda, a failed message then waits its visibility
+// timeout and reaches the dead letter queue through the redrive policy
+var requeue requeuer
+
+// minRequeueDelay keeps a message away while the breaker probes MEFE
+const minRequeueDelay = 5 * time.Second
+
+// maxDeadLetterReason keeps a long MEFE answer from pushing a dead-lettered
+// message over the SQS size limit
+const maxDeadLetterReason = 1024
+
+// sqsRequeuer changes the message visibility or sends it to the
+// DEAD_LETTER_QUEUE_URL queue
+type sqsRequeuer struct {
+	svc *sqs.SQS
+	dlq string
+}
+
+func (q sqsRequeuer) Requeue(ctx context.Context, record SQSrecord, delay time.Duration) error {
+	url, err := queueURL(record.EventSourceARN)
+	if err != nil {
+		return err
+	}
+	req := q.svc.ChangeMessageVisibilityRequest(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(url),
+		ReceiptHandle:     aws.String(record.ReceiptHandle),
+		VisibilityTimeout: aws.Int64(int64((delay + time.Second - 1) / time.Second)),
+	})
+	req.SetContext(ctx)
+	_, err = req.Send()
+	return err
+}
+
+func (q sqsRequeuer) DeadLetter(ctx context.Context, record SQSrecord, reason error) error {
+	if q.dlq == "" {
+		return fmt.Errorf("no DEAD_LETTER_QUEUE_URL")
+	}
+	req := q.svc.SendMessageRequest(deadLetterInput(q.dlq, record, reason))
+	req.SetContext(ctx)
+	_, err := req.Send()
+	return err
+}
+
+// deadLetterInput copies record to the dlq queue with why it failed and
+// how many times it was received
+func deadLetterInput(dlq string, record SQSrecord, reason error) *sqs.SendMessageInput {
+	attrs := map[string]sqs.MessageAttributeValue{}
+	for key, attr := range record.MessageAttributes {
+		if attr.StringValue != nil {
+			attrs[key] = sqs.MessageAttributeValue{DataType: aws.String(attr.DataType), StringValue: attr.StringValue}
+		}
+	}
+	attrs[payload.AttrDeadLetterReason] = sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(truncate(reason.Error(), maxDeadLetterReason))}
+	if n := record.Attributes.ApproximateReceiveCount; n != "" {
+		attrs[payload.AttrReceiveCount] = sqs.MessageAttributeValue{DataType: aws.String("Number"), StringValue: aws.String(n)}
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(dlq),
+		MessageBody:       aws.String(record.Body),
+		MessageAttributes: attrs,
+	}
+	if strings.HasSuffix(dlq, ".fifo") {
+		// Deduplicated by message ID, so a redriven message that fails again
+		// within the deduplication interval is not dropped
+		group := record.Attributes.MessageGroupID
+		if group == "" {
+			group = "deadLetter"
+		}
+		input.MessageGroupId, input.MessageDeduplicationId = aws.String(group), aws.String(record.MessageID)
+	}
+	return input
+}
+
+// truncate cuts s to at most n bytes, on a rune boundary
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// queueURL turns arn:aws:sqs:region:account:name into the queue URL
+func queueURL(arn string) (string, error) {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[2] != "sqs" {
+		return "", fmt.Errorf("not an SQS queue ARN: %q", arn)
+	}
+	return fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", parts[3], parts[4], parts[5]), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// defaultRetry retries a transient MEFE failure twice within the Process timeout
+var defaultRetry = payload.RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   payload.Duration{Duration: 200 * time.Millisecond},
+	MaxDelay:    payload.Duration{Duration: 2 * time.Second},
+	Budget:      payload.Duration{Duration: 10 * time.Second},
+}
+
+// retryMargin is kept from the invocation deadline for the reply call
+const retryMargin = 2 * time.Second
+
+// sleep waits d unless ctx is done first
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// transientStatus are the MEFE answers worth retrying, 4xx fail fast
+func transientStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// transientError tells timeouts and connection resets from errors that won't go away
+func transientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff is the full jitter delay before retry n, counting from 1
+func backoff(p payload.RetryPolicy, n int) time.Duration {
+	d := p.MaxDelay.Duration
+	if n < 32 && p.BaseDelay.Duration<<uint(n-1) < d {
+		d = p.BaseDelay.Duration << uint(n-1)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// post sends the request build makes, retrying transient failures under p,
+// within the time ctx leaves. The last status and body are returned, err only
+// when MEFE never answered.
+func (c withRequestID) post(ctx context.Context, p payload.RetryPolicy, build func() (*http.Request, error)) (status int, body []byte, err error) {
+	start := time.Now()
+	budget := p.Budget.Duration
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline)-retryMargin < budget {
+		budget = time.Until(deadline) - retryMargin
+	}
+	for attempt := 1; ; attempt++ {
+		status, body, err = send(ctx, build)
+		transient := transientError(err) || (err == nil && transientStatus(status))
+		if !transient || attempt >= p.MaxAttempts {
+			return status, body, err
+		}
+		delay := backoff(p, attempt)
+		if time.Since(start)+delay > budget {
+			c.log.WithField("attempt", attempt).Warn("retry budget spent")
+			return status, body, err
+		}
+		c.log.WithFields(log.Fields{
+			"attempt": attempt,
+			"status":  status,
+			"error":   err,
+			"delay":   delay,
+			"breaker": mefeBreaker.State(),
+		}).Warn("retrying MEFE")
+		if sleep(ctx, delay) != nil {
+			return status, body, err
+		}
+	}
+}
+
+// send makes one MEFE request through mefeBreaker
+func send(ctx context.Context, build func() (*http.Request, error)) (status int, body []byte, err error) {
+	req, err := build()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := mefeBreaker.Allow(); err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		mefeBreaker.Record(err == nil && status < http.StatusInternalServerError)
+	}()
+	res, err := mefeClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	body, err = ioutil.ReadAll(res.Body)
+	return res.StatusCode, body, err
+}
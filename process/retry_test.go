@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// noSleep records the backoff delays instead of waiting
+func noSleep(t *testing.T) *[]time.Duration {
+	delays := &[]time.Duration{}
+	old := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+	t.Cleanup(func() { sleep = old })
+	return delays
+}
+
+func Test_post(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		policy       payload.RetryPolicy
+		wantStatus   int
+		wantAttempts int
+	}{
+		{"recovers", []int{503, 502, 201}, defaultRetry, 201, 3},
+		{"gives up", []int{504, 504, 504, 504}, defaultRetry, 504, 3},
+		{"4xx fails fast", []int{400, 201}, defaultRetry, 400, 1},
+		{"500 is not transient", []int{500, 201}, defaultRetry, 500, 1},
+		{"per action attempts", []int{503, 201}, payload.RetryPolicy{MaxAttempts: 1}.Or(defaultRetry), 503, 1},
+		{"budget spent", []int{503, 201}, payload.RetryPolicy{Budget: payload.Duration{Duration: time.Nanosecond}}.Or(defaultRetry), 503, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetBreaker(t)
+			delays := noSleep(t)
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[attempts])
+				attempts++
+			}))
+			defer srv.Close()
+
+			c := withRequestID{log: log.WithFields(log.Fields{})}
+			status, _, err := c.post(context.Background(), tt.policy, func() (*http.Request, error) {
+				return http.NewRequest("POST", srv.URL, nil)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus || attempts != tt.wantAttempts {
+				t.Errorf("post() = %d after %d attempts, want %d after %d", status, attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			for i, d := range *delays {
+				if d < 0 || d >= tt.policy.BaseDelay.Duration<<uint(i) {
+					t.Errorf("delay %d = %v", i, d)
+				}
+			}
+		})
+	}
+}
+
+func Test_postDeadline(t *testing.T) {
+	resetBreaker(t)
+	noSleep(t)
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// The invocation has no time left to retry in, whatever the budget
+	ctx, cancel := context.WithTimeout(context.Background(), retryMargin)
+	defer cancel()
+	c := withRequestID{log: log.WithFields(log.Fields{})}
+	status, _, err := c.post(ctx, defaultRetry, func() (*http.Request, error) {
+		return http.NewRequest("POST", srv.URL, nil)
+	})
+	if err != nil || status != http.StatusServiceUnavailable || attempts != 1 {
+		t.Errorf("post() = %d, %v after %d attempts, want no retry", status, err, attempts)
+	}
+}
+
+func Test_postTransientError(t *testing.T) {
+	resetBreaker(t)
+	noSleep(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	attempts := 0
+	c := withRequestID{log: log.WithFields(log.Fields{})}
+	_, _, err := c.post(context.Background(), defaultRetry, func() (*http.Request, error) {
+		attempts++
+		return http.NewRequest("POST", url, nil)
+	})
+	if err == nil || attempts != defaultRetry.MaxAttempts {
+		t.Errorf("post() = %v after %d attempts", err, attempts)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/go-sql-driver/mysql"
+	"github.com/unee-t/env"
+	"github.com/unee-t/lambda2sqs/config"
+)
+
+// initializer runs the cold start setup on the first invocation. A failed
+// setup is retried by the next invocation instead of crash-looping.
+type initializer struct {
+	fn func(ctx context.Context) error
+
+	mu   sync.Mutex
+	done bool
+}
+
+// Do runs fn unless it already succeeded
+func (i *initializer) Do(ctx context.Context) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.done {
+		return nil
+	}
+	if err := i.fn(ctx); err != nil {
+		return err
+	}
+	i.done = true
+	return nil
+}
+
+// setup connects process to AWS, MEFE and the enterprise DB
+var setup = &initializer{fn: initialize}
+
+// ready sets process up and checks the DB connection
+func ready(ctx context.Context) error {
+	if err := setup.Do(ctx); err != nil {
+		return transient(fmt.Errorf("setup: %w", err), 0)
+	}
+	if DB == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, conf.Process.DB.PingTimeout.Duration)
+	defer cancel()
+	if err := DB.PingContext(ctx); err != nil {
+		return transient(fmt.Errorf("database ping: %w", err), 0)
+	}
+	return nil
+}
+
+// initialize runs in order, each step needs the one before: the AWS config
+// for the SSM secrets, the secrets for the DB
+func initialize(ctx context.Context) error {
+	start := time.Now()
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	blobs = newBlobStore(cfg)
+	requeue = sqsRequeuer{svc: sqs.New(cfg), dlq: conf.Process.DeadLetterQueueURL}
+
+	e, err := env.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to setup unee-t env: %w", err)
+	}
+	if err := conf.Resolve(e); err != nil {
+		return err
+	}
+	if err := conf.ValidateProcess(); err != nil {
+		return err
+	}
+	log.WithField("config", conf.Summary()).Info("config")
+
+	db, err := sql.Open("mysql", dsn(conf.Process.DB))
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	configurePool(db, conf.Process.DB)
+
+	DB, idempotency = db, newIdempotencyStore(db, conf.Process.Idempotency)
+	mefeClient.Timeout = conf.Process.MEFE.Timeout.Duration
+	log.WithField("took", time.Since(start)).Info("setup")
+	return nil
+}
+
+// dsn is the go-sql-driver/mysql data source name of the enterprise DB
+func dsn(c config.DB) string {
+	m := mysql.NewConfig()
+	m.User = c.User
+	m.Passwd = string(c.Password)
+	m.Net = "tcp"
+	m.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	m.DBName = c.Name
+	m.Timeout = c.ConnectTimeout.Duration
+	m.Collation = "utf8mb4_unicode_520_ci"
+	m.Params = map[string]string{"sql_mode": "TRADITIONAL"}
+	return m.FormatDSN()
+}
+
+// configurePool sizes the connection pool
+func configurePool(db *sql.DB, c config.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime.Duration)
+}
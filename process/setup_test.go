@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/unee-t/lambda2sqs/config"
+)
+
+func Test_initializer(t *testing.T) {
+	calls := 0
+	i := &initializer{fn: func(context.Context) error {
+		calls++
+		if calls == 1 {
+			return errors.New("SSM throttled")
+		}
+		return nil
+	}}
+	if err := i.Do(context.Background()); err == nil {
+		t.Fatal("Do() hid the setup error")
+	}
+	if err := i.Do(context.Background()); err != nil {
+		t.Fatalf("Do() error = %v on retry", err)
+	}
+	i.Do(context.Background())
+	if calls != 2 {
+		t.Errorf("setup ran %d times, want 2", calls)
+	}
+}
+
+func Test_readyNotSetUp(t *testing.T) {
+	defer func(s *initializer) { setup = s }(setup)
+	setup = &initializer{fn: func(context.Context) error { return errors.New("STS unreachable") }}
+
+	err := ready(context.Background())
+	if o, _ := decide(err); o != retry {
+		t.Errorf("ready() = %v, decided %s", err, o)
+	}
+	res, err := handler(context.Background(), sqsEvent(caseNewMessage))
+	if err == nil || res != nil {
+		t.Errorf("handler() = %v, %v, want the batch back", res, err)
+	}
+}
+
+func Test_dsn(t *testing.T) {
+	c := config.Default().Process.DB
+	c.Host, c.User, c.Password, c.Port = "auroradb.dev.unee-t.com", "lambda", "p@ss:word", 3307
+	want := "lambda:p@ss:word@tcp(auroradb.dev.unee-t.com:3307)/unee_t_enterprise?collation=utf8mb4_unicode_520_ci&timeout=5s&sql_mode=TRADITIONAL"
+	if got := dsn(c); got != want {
+		t.Errorf("dsn() = %s, want %s", got, want)
+	}
+
+	db, err := sql.Open("mysql", dsn(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c.MaxOpenConns = 3
+	configurePool(db, c)
+	if got := db.Stats().MaxOpenConnections; got != 3 {
+		t.Errorf("MaxOpenConnections = %d, want 3", got)
+	}
+}
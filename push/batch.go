@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+const (
+	// SQS accepts at most 10 entries per SendMessageBatch
+	batchSize     = 10
+	batchAttempts = 3
+)
+
+var batchBackoff = 200 * time.Millisecond
+
+// payloads splits a JSON array or a { "messages": [...] } envelope into its
+// payloads, otherwise evt is a single payload
+func payloads(evt json.RawMessage) (out []json.RawMessage, batch bool, err error) {
+	trimmed := bytes.TrimSpace(evt)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		err = json.Unmarshal(trimmed, &out)
+		return out, true, err
+	}
+	var envelope struct {
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if json.Unmarshal(trimmed, &envelope) == nil && envelope.Messages != nil {
+		return envelope.Messages, true, nil
+	}
+	return []json.RawMessage{evt}, false, nil
+}
+
+// batchEntry converts a SendMessageInput into an entry of a SendMessageBatch
+func batchEntry(id string, input *sqs.SendMessageInput) sqs.SendMessageBatchRequestEntry {
+	return sqs.SendMessageBatchRequestEntry{
+		Id:                     aws.String(id),
+		MessageAttributes:      input.MessageAttributes,
+		MessageBody:            input.MessageBody,
+		MessageDeduplicationId: input.MessageDeduplicationId,
+		MessageGroupId:         input.MessageGroupId,
+	}
+}
+
+// batchSendFunc sends one SendMessageBatch and returns its failed entries
+type batchSendFunc func(ctx context.Context, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error)
+
+// enqueueBatch sends entries in chunks SQS accepts, retrying only the failed
+// entries that were not the sender's fault. It returns the entries that
+// could not be enqueued, keyed by entry Id.
+func enqueueBatch(ctx context.Context, send batchSendFunc, entries []sqs.SendMessageBatchRequestEntry) (failed map[string]string) {
+	failed = map[string]string{}
+	for _, pending := range chunks(entries) {
+		for attempt := 1; len(pending) > 0; attempt++ {
+			if attempt > 1 {
+				time.Sleep(batchBackoff * time.Duration(attempt-1))
+			}
+			results, err := send(ctx, pending)
+			if err != nil {
+				log.WithError(err).WithField("attempt", attempt).Warn("SendMessageBatch failed")
+				for _, e := range pending {
+					failed[*e.Id] = err.Error()
+				}
+				if attempt == batchAttempts {
+					break
+				}
+				continue
+			}
+			for _, e := range pending {
+				delete(failed, *e.Id)
+			}
+			var retry []sqs.SendMessageBatchRequestEntry
+			for _, r := range results {
+				failed[aws.StringValue(r.Id)] = fmt.Sprintf("%s: %s", aws.StringValue(r.Code), aws.StringValue(r.Message))
+				if !aws.BoolValue(r.SenderFault) && attempt < batchAttempts {
+					retry = append(retry, entryByID(pending, aws.StringValue(r.Id)))
+				}
+			}
+			pending = retry
+		}
+	}
+	return failed
+}
+
+// chunks splits entries into batches SQS accepts, by count and by total size
+func chunks(entries []sqs.SendMessageBatchRequestEntry) (out [][]sqs.SendMessageBatchRequestEntry) {
+	var chunk []sqs.SendMessageBatchRequestEntry
+	size := 0
+	for _, e := range entries {
+		n := messageSize(&sqs.SendMessageInput{MessageBody: e.MessageBody, MessageAttributes: e.MessageAttributes})
+		if len(chunk) == batchSize || len(chunk) > 0 && size+n > payload.MaxMessageSize {
+			out = append(out, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, e)
+		size += n
+	}
+	if len(chunk) > 0 {
+		out = append(out, chunk)
+	}
+	return out
+}
+
+func entryByID(entries []sqs.SendMessageBatchRequestEntry, id string) sqs.SendMessageBatchRequestEntry {
+	for _, e := range entries {
+		if *e.Id == id {
+			return e
+		}
+	}
+	return sqs.SendMessageBatchRequestEntry{Id: aws.String(id)}
+}
+
+// sentRecord remembers what an invocation enqueued, by Lambda request ID,
+// payload position and destination, so when Lambda retries an invocation
+// that partly failed only what failed is sent again. It lasts as long as the
+// container, process's idempotency table catches the duplicates of a retry
+// landing on another one. Outside Lambda, as under serve, nothing is recorded.
+type sentRecord struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu     sync.Mutex
+	keys   map[string]time.Time
+	purged time.Time
+}
+
+// sent covers the 6 hours Lambda retries an asynchronous invocation for
+var sent = newSentRecord(6 * time.Hour)
+
+func newSentRecord(ttl time.Duration) *sentRecord {
+	return &sentRecord{ttl: ttl, now: time.Now, keys: map[string]time.Time{}}
+}
+
+// sentKey identifies payload i of the invocation ctx, empty outside Lambda.
+// The request ID stays the same when Lambda retries an invocation.
+func sentKey(ctx context.Context, i int, dest string) string {
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok || lc.AwsRequestID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s #%d %s", lc.AwsRequestID, i, dest)
+}
+
+// Has is true when payload i of the invocation went to dest within the ttl
+func (r *sentRecord) Has(ctx context.Context, i int, dest string) bool {
+	key := sentKey(ctx, i, dest)
+	if key == "" {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	at, ok := r.keys[key]
+	return ok && r.now().Sub(at) < r.ttl
+}
+
+// Add records payload i of the invocation went to dest, forgetting the expired ones
+func (r *sentRecord) Add(ctx context.Context, i int, dest string) {
+	key := sentKey(ctx, i, dest)
+	if key == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Sub(r.purged) >= time.Minute {
+		for key, at := range r.keys {
+			if now.Sub(at) >= r.ttl {
+				delete(r.keys, key)
+			}
+		}
+		r.purged = now
+	}
+	r.keys[key] = now
+}
+
+// batchFailure reports every payload of a batch that failed, by its position
+// in the batch, with a reason per destination it failed to reach
+type batchFailure struct {
+	Failed map[string][]string `json:"failed"`
+}
+
+func batchError(failed map[string][]string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return &batchFailure{Failed: failed}
+}
+
+func (e *batchFailure) Error() string {
+	ids := make([]string, 0, len(e.Failed))
+	for id := range e.Failed {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+	msgs := make([]string, len(ids))
+	for i, id := range ids {
+		msgs[i] = fmt.Sprintf("#%s: %s", id, strings.Join(e.Failed[id], ", "))
+	}
+	return fmt.Sprintf("%d payload(s) failed: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/config"
+)
+
+func Test_payloads(t *testing.T) {
+	tests := []struct {
+		name      string
+		evt       string
+		wantLen   int
+		wantBatch bool
+		wantErr   bool
+	}{
+		{name: "single", evt: createUnitMessage, wantLen: 1},
+		{name: "array", evt: ` [{"a": 1}, {"b": 2}]`, wantLen: 2, wantBatch: true},
+		{name: "envelope", evt: `{"messages": [{"a": 1}, {"b": 2}, {"c": 3}]}`, wantLen: 3, wantBatch: true},
+		{name: "broken array", evt: `[{"a": 1},`, wantBatch: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, batch, err := payloads(json.RawMessage(tt.evt))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("payloads() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.wantLen || batch != tt.wantBatch {
+				t.Errorf("payloads() = %d, %v, want %d, %v", len(got), batch, tt.wantLen, tt.wantBatch)
+			}
+		})
+	}
+}
+
+func Test_enqueueBatch(t *testing.T) {
+	defer func(c config.Config) { conf = c }(conf)
+	conf.Push.FIFO = false
+	defer func(d time.Duration) { batchBackoff = d }(batchBackoff)
+	batchBackoff = 0
+
+	var entries []sqs.SendMessageBatchRequestEntry
+	for i := 0; i < 23; i++ {
+		entries = append(entries, sqs.SendMessageBatchRequestEntry{
+			Id:          aws.String(strconv.Itoa(i)),
+			MessageBody: aws.String(fmt.Sprintf(`{"n": %d}`, i)),
+		})
+	}
+
+	// "3" fails once, "4" always fails and "5" is the sender's fault
+	calls := map[string]int{}
+	var sizes []int
+	send := func(ctx context.Context, batch []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error) {
+		sizes = append(sizes, len(batch))
+		var failed []sqs.BatchResultErrorEntry
+		for _, e := range batch {
+			calls[*e.Id]++
+			switch {
+			case *e.Id == "3" && calls["3"] == 1, *e.Id == "4":
+				failed = append(failed, sqs.BatchResultErrorEntry{Id: e.Id, Code: aws.String("InternalError"), SenderFault: aws.Bool(false)})
+			case *e.Id == "5":
+				failed = append(failed, sqs.BatchResultErrorEntry{Id: e.Id, Code: aws.String("InvalidMessageContents"), SenderFault: aws.Bool(true)})
+			}
+		}
+		return failed, nil
+	}
+
+	failed := enqueueBatch(context.Background(), send, entries)
+	if len(failed) != 2 || failed["4"] == "" || failed["5"] == "" {
+		t.Errorf("enqueueBatch() failed = %v, want 4 and 5", failed)
+	}
+	if calls["3"] != 2 || calls["4"] != batchAttempts || calls["5"] != 1 || calls["0"] != 1 {
+		t.Errorf("enqueueBatch() calls = %v", calls)
+	}
+	if fmt.Sprint(sizes) != fmt.Sprint([]int{10, 2, 1, 10, 3}) {
+		t.Errorf("enqueueBatch() batch sizes = %v", sizes)
+	}
+}
+
+// invocation is the ctx of the Lambda invocation id
+func invocation(id string) context.Context {
+	return lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: id})
+}
+
+func Test_enqueueAll(t *testing.T) {
+	defer func(r *sentRecord) { sent = r }(sent)
+	sent = newSentRecord(time.Hour)
+	s := &memorySender{}
+	msgs := []json.RawMessage{[]byte(`{"name": "x"}`), []byte(`not JSON`), []byte(`{"city": "Room"}`)}
+	err := enqueueAll(invocation("r1"), s, msgs)
+	if err == nil {
+		t.Error("enqueueAll() wants error for #1")
+	}
+	var bodies []string
+	for _, m := range s.Sent() {
+		bodies = append(bodies, m.Body)
+	}
+	if fmt.Sprint(bodies) != `[{"name":"x"} {"city":"Room"}]` {
+		t.Errorf("enqueueAll() sent = %v", bodies)
+	}
+
+	// Lambda retries the failed invocation, only #1 is tried again
+	if err := enqueueAll(invocation("r1"), s, msgs); err == nil || strings.Contains(err.Error(), "#0") {
+		t.Errorf("enqueueAll() retry error = %v, want #1 only", err)
+	}
+	if len(s.Sent()) != 2 {
+		t.Errorf("enqueueAll() retry sent %d messages again", len(s.Sent())-2)
+	}
+
+	// The same payloads in another invocation, or outside Lambda, are sent again
+	enqueueAll(invocation("r2"), s, msgs)
+	enqueueAll(context.Background(), s, msgs)
+	if len(s.Sent()) != 6 {
+		t.Errorf("enqueueAll() sent %d messages, want 6", len(s.Sent()))
+	}
+}
+
+func Test_sentRecord(t *testing.T) {
+	r := newSentRecord(time.Hour)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	ctx := invocation("r1")
+	r.Add(ctx, 0, "https://sqs/a")
+	if !r.Has(ctx, 0, "https://sqs/a") || r.Has(ctx, 0, "https://sqs/b") || r.Has(ctx, 1, "https://sqs/a") || r.Has(invocation("r2"), 0, "https://sqs/a") {
+		t.Error("Has() mixes destinations, payloads or invocations")
+	}
+	r.Add(context.Background(), 0, "https://sqs/a")
+	if r.Has(context.Background(), 0, "https://sqs/a") {
+		t.Error("Has() outside Lambda")
+	}
+	now = now.Add(time.Hour)
+	if r.Has(ctx, 0, "https://sqs/a") {
+		t.Error("Has() after the ttl")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// messageSize counts the body and attributes the way SQS does against MaxMessageSize
+func messageSize(input *sqs.SendMessageInput) (size int) {
+	size = len(aws.StringValue(input.MessageBody))
+	for name, attr := range input.MessageAttributes {
+		size += len(name) + len(aws.StringValue(attr.DataType)) + len(aws.StringValue(attr.StringValue)) + len(attr.BinaryValue)
+	}
+	return size
+}
+
+// offload swaps an oversized body for a claim check pointing at the stored payload
+func offload(ctx context.Context, input *sqs.SendMessageInput) error {
+	size := messageSize(input)
+	if size <= payload.MaxMessageSize {
+		return nil
+	}
+	if blobs == nil {
+		return fmt.Errorf("payload of %d bytes exceeds %d and no CLAIM_CHECK_BUCKET is configured", size, payload.MaxMessageSize)
+	}
+	pointer, err := payload.NewClaimCheck(ctx, blobs, []byte(aws.StringValue(input.MessageBody)))
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"size":       size,
+		"claimCheck": string(pointer),
+	}).Info("offloaded oversized payload")
+	input.MessageBody = aws.String(string(pointer))
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+func Test_offload(t *testing.T) {
+	defer func(b payload.BlobStore) { blobs = b }(blobs)
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	large := `{"notification_type": "case_new_message", "message_truncated": "` + strings.Repeat("x", payload.MaxMessageSize) + `"}`
+	blobs = nil
+	if err := offload(ctx, &sqs.SendMessageInput{MessageBody: aws.String(large)}); err == nil {
+		t.Error("offload() without a blob store wants error")
+	}
+
+	blobs = payload.DirStore{Dir: dir}
+	small := &sqs.SendMessageInput{MessageBody: aws.String(createUnitMessage)}
+	if err := offload(ctx, small); err != nil || *small.MessageBody != createUnitMessage {
+		t.Errorf("offload() changed a small payload: %v", err)
+	}
+
+	input := &sqs.SendMessageInput{MessageBody: aws.String(large)}
+	if err := offload(ctx, input); err != nil {
+		t.Fatalf("offload() error = %v", err)
+	}
+	cc := payload.ParseClaimCheck([]byte(*input.MessageBody))
+	if cc == nil {
+		t.Fatalf("offload() body = %.100s, want a claim check", *input.MessageBody)
+	}
+	stored, err := blobs.Get(ctx, cc.Key)
+	if err != nil || string(stored) != large {
+		t.Errorf("offload() stored %d bytes, %v", len(stored), err)
+	}
+}
+
+func Test_chunks(t *testing.T) {
+	var entries []sqs.SendMessageBatchRequestEntry
+	for i := 0; i < 4; i++ {
+		entries = append(entries, sqs.SendMessageBatchRequestEntry{
+			Id:          aws.String(strconv.Itoa(i)),
+			MessageBody: aws.String(strings.Repeat("x", payload.MaxMessageSize/3)),
+		})
+	}
+	got := chunks(entries)
+	if len(got) != 2 || len(got[0]) != 3 || len(got[1]) != 1 {
+		t.Errorf("chunks() = %d chunks", len(got))
+	}
+}
@@ -6,6 +6,13 @@ require (
 	github.com/apex/log v1.1.1
 	github.com/aws/aws-lambda-go v1.13.2
 	github.com/aws/aws-sdk-go-v2 v0.11.0
-	golang.org/x/net v0.0.0-20190912160710-24e19bdeb0f2 // indirect
-	golang.org/x/text v0.3.2 // indirect
+	github.com/unee-t/lambda2sqs/blobstore v0.0.0
+	github.com/unee-t/lambda2sqs/config v0.0.0
+	github.com/unee-t/lambda2sqs/payload v0.0.0
 )
+
+replace github.com/unee-t/lambda2sqs/payload => ../payload
+
+replace github.com/unee-t/lambda2sqs/config => ../config
+
+replace github.com/unee-t/lambda2sqs/blobstore => ../blobstore
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/apex/log"
 	jsonhandler "github.com/apex/log/handlers/json"
@@ -13,104 +15,286 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/blobstore"
+	"github.com/unee-t/lambda2sqs/config"
+	"github.com/unee-t/lambda2sqs/payload"
 )
 
 var (
-	qURL = os.Getenv("SQS_URL")
+	// conf holds the settings, spec and routes are parsed from it
+	conf   config.Config
+	spec   = payload.DefaultDecodingSpec
+	routes = routingTable{Default: defaultDestinations()}
+	// blobs holds the payloads too large for SQS
+	blobs payload.BlobStore
 )
 
 func main() {
 	log.SetHandler(jsonhandler.Default)
-	lambda.Start(handler)
-}
+	var err error
+	conf, err = config.Load(os.Getenv)
+	if err != nil {
+		log.WithError(err).Fatal("failed to load config")
+	}
+	log.WithField("config", conf.Summary()).Info("config")
+	spec, err = payload.LoadDecodingSpec(conf.Push.DecodingSpec)
+	if err != nil {
+		log.WithError(err).Fatal("failed to load DECODING_SPEC")
+	}
+	routes, err = loadRoutes(conf.Push.Routes)
+	if err != nil {
+		log.WithError(err).Fatal("failed to load ROUTES")
+	}
+	defs, err := payload.LoadActionDefinitions(conf.ActionTypes)
+	if err != nil {
+		log.WithError(err).Fatal("failed to load ACTION_TYPES")
+	}
+	payload.Define(defs)
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		err = serve(os.Args[2:])
+		if err != nil {
+			log.WithError(err).Fatal("serve")
+		}
+		return
+	}
 
-func handler(ctx context.Context, evt json.RawMessage) error {
+	if err := conf.ValidatePush(); err != nil {
+		log.WithError(err).Fatal("invalid config")
+	}
 	cfg, err := external.LoadDefaultAWSConfig()
 	if err != nil {
-		log.WithError(err).Error("failed to load AWS config")
-		return err
+		log.WithError(err).Fatal("failed to load AWS config")
 	}
+	blobs = blobstore.New(cfg, conf.ClaimCheck)
+	lambda.Start(handler(newAWSSender(cfg)))
+}
+
+// handler enqueues each invocation's payload through s
+func handler(s Sender) func(ctx context.Context, evt json.RawMessage) error {
+	return func(ctx context.Context, evt json.RawMessage) error {
+		return enqueue(ctx, s, evt)
+	}
+}
+
+// enqueue runs a single or batched payload through digest, validation and delivery
+func enqueue(ctx context.Context, s Sender, evt json.RawMessage) error {
 	log.WithField("raw", string(evt)).Info("incoming")
-	base64Decoding, err := digest(evt)
+	msgs, batch, err := payloads(evt)
 	if err != nil {
-		log.WithError(err).Error("failed to decode payload")
+		log.WithError(err).Error("failed to split payloads")
 		return err
 	}
 
-	// deduplicationID, groupID, err := id(evt)
-	// if err != nil {
-	// 	log.WithError(err).Error("unable to ID payload")
-	// 	return err
-	// }
-
-	svc := sqs.New(cfg)
-	req := svc.SendMessageRequest(&sqs.SendMessageInput{
-		MessageBody: aws.String(string(base64Decoding)),
-		// MessageDeduplicationId: aws.String(deduplicationID),
-		// MessageGroupId:         aws.String(groupID),
-		QueueUrl: &qURL,
-	})
-	_, err = req.Send(context.TODO())
+	if batch {
+		return enqueueAll(ctx, s, msgs)
+	}
+
+	input, err := prepare(ctx, evt)
 	if err != nil {
-		log.WithError(err).Error("failed to send")
 		return err
 	}
-	log.WithField("payload", string(base64Decoding)).Info("enqueued")
+	// Every destination is tried, so a retry only sends to the ones that failed
+	dests := routes.destinations(routeAttributes(input))
+	var failed []string
+	for _, dest := range dests {
+		if sent.Has(ctx, 0, dest) {
+			continue
+		}
+		err = s.Send(ctx, dest, input)
+		if err != nil {
+			log.WithError(err).WithField("destination", dest).Error("failed to send")
+			failed = append(failed, dest+": "+err.Error())
+			continue
+		}
+		sent.Add(ctx, 0, dest)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send to %d of %d destinations: %s", len(failed), len(dests), strings.Join(failed, "; "))
+	}
+	log.WithFields(log.Fields{
+		"payload":      *input.MessageBody,
+		"destinations": dests,
+		"fifo":         conf.Push.FIFO,
+	}).Info("enqueued")
 	return nil
 }
 
-func id(evt json.RawMessage) (deduplicationId, groupId string, err error) {
-	// Check if action type
-	type actionType struct {
-		MEFIRequestID string `json:"mefeAPIRequestId"`
+// enqueueAll prepares every payload and sends them to their destinations in
+// batches. The error only lists what still failed after the retries, and a
+// retried invocation skips what was already sent.
+func enqueueAll(ctx context.Context, s Sender, msgs []json.RawMessage) error {
+	failed := map[string][]string{}
+	var dests []string
+	entries := map[string][]sqs.SendMessageBatchRequestEntry{}
+	for i, msg := range msgs {
+		input, err := prepare(ctx, msg)
+		if err != nil {
+			failed[strconv.Itoa(i)] = []string{err.Error()}
+			continue
+		}
+		for _, dest := range routes.destinations(routeAttributes(input)) {
+			if sent.Has(ctx, i, dest) {
+				// Sent by the invocation Lambda is retrying
+				continue
+			}
+			if _, ok := entries[dest]; !ok {
+				dests = append(dests, dest)
+			}
+			entries[dest] = append(entries[dest], batchEntry(strconv.Itoa(i), forDestination(input, dest)))
+		}
 	}
-	var act actionType
-	err = json.Unmarshal(evt, &act)
+	for _, dest := range dests {
+		send := func(ctx context.Context, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error) {
+			return s.SendBatch(ctx, dest, entries)
+		}
+		destFailed := enqueueBatch(ctx, send, entries[dest])
+		for _, e := range entries[dest] {
+			if reason, ok := destFailed[*e.Id]; ok {
+				failed[*e.Id] = append(failed[*e.Id], dest+": "+reason)
+			} else {
+				i, _ := strconv.Atoi(*e.Id)
+				sent.Add(ctx, i, dest)
+			}
+		}
+	}
+	log.WithFields(log.Fields{
+		"total":        len(msgs),
+		"failed":       failed,
+		"destinations": dests,
+		"fifo":         conf.Push.FIFO,
+	}).Info("enqueued batch")
+	err := batchError(failed)
 	if err != nil {
-		log.WithError(err).Error("failed to parse as actionType")
+		log.WithError(err).Error("failed to send")
 	}
-	if act.MEFIRequestID != "" {
-		return act.MEFIRequestID, "actionType", nil
+	return err
+}
+
+// prepare decodes a single payload into the message to send
+func prepare(ctx context.Context, evt json.RawMessage) (*sqs.SendMessageInput, error) {
+	base64Decoding, decoded, err := digest(evt)
+	if err != nil {
+		log.WithError(err).Error("failed to decode payload")
+		return nil, err
 	}
-	// Check if notification type
-	type notificationType struct {
-		NotificationID string `json:"notification_id"`
+	log.WithField("decoded", decoded).Info("decoded fields")
+
+	// Reject now what process would reject after several redeliveries
+	err = payload.Validate(base64Decoding)
+	if err != nil {
+		log.WithError(err).WithField("payload", string(base64Decoding)).Error("invalid payload")
+		return nil, err
 	}
-	var notification notificationType
-	err = json.Unmarshal(evt, &notification)
+
+	input, err := sendMessageInput(evt, base64Decoding)
 	if err != nil {
-		log.WithError(err).Error("failed to parse as notificationType")
+		log.WithError(err).Error("unable to ID payload")
+		return nil, err
 	}
-	if notification.NotificationID != "" {
-		return notification.NotificationID, "notificationType", nil
+
+	err = offload(ctx, input)
+	if err != nil {
+		log.WithError(err).Error("failed to offload payload")
+		return nil, err
 	}
-	return "", "", fmt.Errorf("failed to id payload: %+v", string(evt))
+	return input, nil
 }
 
-func digest(evt json.RawMessage) (out json.RawMessage, err error) {
-	var input interface{}
-	err = json.Unmarshal(evt, &input)
+// sendMessageInput only sets the FIFO fields when the queue is FIFO
+func sendMessageInput(evt, body json.RawMessage) (*sqs.SendMessageInput, error) {
+	input := &sqs.SendMessageInput{
+		MessageAttributes: messageAttributes(body),
+		MessageBody:       aws.String(string(body)),
+		QueueUrl:          aws.String(conf.Push.QueueURL),
+	}
+	if !conf.Push.FIFO {
+		return input, nil
+	}
+	deduplicationID, groupID, err := id(evt)
 	if err != nil {
-		return out, err
-	}
-	log.WithField("input", input).Debug("input")
-	if rec, ok := input.(map[string]interface{}); ok {
-		for key, val := range rec {
-			log.Infof(" [========>] %s = %s", key, val)
-			switch key {
-			case "firstName", "lastName", "phoneNumber", "name", "moreInfo", "streetAddress", "city", "state":
-				if val, ok := val.(string); ok {
-					data, err := base64.StdEncoding.DecodeString(val)
-					if err != nil {
-						log.WithError(err).Debug("ignore not base64")
-						data = []byte(val)
-					}
-					log.WithField("data", string(data)).Debug("decoded")
-					rec[key] = string(data)
-				}
-			}
+		return nil, err
+	}
+	input.MessageDeduplicationId = aws.String(deduplicationID)
+	input.MessageGroupId = aws.String(groupID)
+	return input, nil
+}
+
+// messageAttributes types the payload metadata so process can route without parsing the body
+func messageAttributes(body json.RawMessage) map[string]sqs.MessageAttributeValue {
+	attrs := map[string]sqs.MessageAttributeValue{
+		payload.AttrSchemaVersion: {
+			DataType:    aws.String("Number"),
+			StringValue: aws.String(payload.SchemaVersion),
+		},
+	}
+	if conf.Stage != "" {
+		attrs[payload.AttrStage] = sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(conf.Stage),
+		}
+	}
+	for key, val := range payload.Attributes(body) {
+		attrs[key] = sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(val),
 		}
-		out, err = json.Marshal(rec)
 	}
-	return
+	return attrs
+}
+
+// id returns the deduplication ID and a group ID per entity, so messages about
+// the same unit, user or case are processed in order
+func id(evt json.RawMessage) (deduplicationId, groupId string, err error) {
+	d := json.NewDecoder(bytes.NewReader(evt))
+	d.UseNumber() // IDs are sometimes numbers, sometimes strings
+	var rec map[string]interface{}
+	err = d.Decode(&rec)
+	if err != nil {
+		return "", "", &payload.ValidationError{Reason: fmt.Sprintf("failed to id payload: %+v", string(evt))}
+	}
+
+	// Check if action type
+	if deduplicationId = field(rec, "mefeAPIRequestId"); deduplicationId != "" {
+		return deduplicationId, group(rec, "actionType",
+			"unitId", "unit",
+			"userId", "user",
+			"unitCreationRequestId", "unitCreationRequest",
+			"userCreationRequestId", "userCreationRequest",
+		), nil
+	}
+	// Check if notification type
+	if deduplicationId = field(rec, "notification_id"); deduplicationId != "" {
+		return deduplicationId, group(rec, "notificationType",
+			"case_id", "case",
+			"unit_id", "unit",
+			"user_id", "user",
+		), nil
+	}
+	return "", "", &payload.ValidationError{Reason: fmt.Sprintf("failed to id payload: %+v", string(evt))}
+}
+
+// group returns the first "prefix-value" found from the key, prefix pairs, else the fallback
+func group(rec map[string]interface{}, fallback string, pairs ...string) string {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if v := field(rec, pairs[i]); v != "" {
+			return pairs[i+1] + "-" + v
+		}
+	}
+	return fallback
+}
+
+// field returns a string or number value as a string
+func field(rec map[string]interface{}, key string) string {
+	switch v := rec[key].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// digest decodes the base64 encoded fields of a payload and returns which ones it decoded
+func digest(evt json.RawMessage) (out json.RawMessage, decoded []string, err error) {
+	return payload.Digest(evt, spec, conf.Push.DecodingStrict)
 }
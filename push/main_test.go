@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/unee-t/lambda2sqs/config"
+	"github.com/unee-t/lambda2sqs/payload"
 )
 
 var createUnitMessage = `
@@ -65,7 +70,7 @@ func Test_digest(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotOut, err := digest(tt.args.evt)
+			gotOut, _, err := digest(tt.args.evt)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("digest() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -113,7 +118,7 @@ func Test_id(t *testing.T) {
         }`),
 			},
 			wantDeduplicationId: "ut_notification_message_new-80675",
-			wantGroupId:         "notificationType",
+			wantGroupId:         "case-70175",
 			wantErr:             false,
 		},
 		{
@@ -122,7 +127,25 @@ func Test_id(t *testing.T) {
 				evt: []byte(createUnitMessage),
 			},
 			wantDeduplicationId: "e7bb7494-bfa3-11e9-a563-06358cf32556",
-			wantGroupId:         "actionType",
+			wantGroupId:         "unitCreationRequest-4771",
+			wantErr:             false,
+		},
+		{
+			name: "numeric mefeAPIRequestId",
+			args: args{
+				evt: []byte(`{"unitId": "jAPsg5sZBjSDT9QSD", "actionType": "ASSIGN_ROLE", "addedUserId": "wQY75SMMHbMv5jnhe", "mefeAPIRequestId": 1}`),
+			},
+			wantDeduplicationId: "1",
+			wantGroupId:         "unit-jAPsg5sZBjSDT9QSD",
+			wantErr:             false,
+		},
+		{
+			name: "no entity",
+			args: args{
+				evt: []byte(`{"notification_id": "ut_notification_message_new-1"}`),
+			},
+			wantDeduplicationId: "ut_notification_message_new-1",
+			wantGroupId:         "notificationType",
 			wantErr:             false,
 		},
 		{
@@ -151,3 +174,166 @@ func Test_id(t *testing.T) {
 		})
 	}
 }
+
+func Test_sendMessageInput(t *testing.T) {
+	defer func(c config.Config) { conf = c }(conf)
+	evt := json.RawMessage(createUnitMessage)
+
+	conf.Push.FIFO = false
+	input, err := sendMessageInput(evt, evt)
+	if err != nil {
+		t.Fatalf("sendMessageInput() standard error = %v", err)
+	}
+	if input.MessageDeduplicationId != nil || input.MessageGroupId != nil {
+		t.Errorf("sendMessageInput() standard queue got FIFO fields %+v", input)
+	}
+
+	conf.Push.FIFO = true
+	input, err = sendMessageInput(evt, evt)
+	if err != nil {
+		t.Fatalf("sendMessageInput() fifo error = %v", err)
+	}
+	if *input.MessageDeduplicationId != "e7bb7494-bfa3-11e9-a563-06358cf32556" {
+		t.Errorf("sendMessageInput() MessageDeduplicationId = %v", *input.MessageDeduplicationId)
+	}
+	if *input.MessageGroupId != "unitCreationRequest-4771" {
+		t.Errorf("sendMessageInput() MessageGroupId = %v", *input.MessageGroupId)
+	}
+}
+
+func Test_digestMarkers(t *testing.T) {
+	defer func(c config.Config) { conf = c }(conf)
+	tests := []struct {
+		name        string
+		strict      bool
+		evt         string
+		wantOut     string
+		wantDecoded []string
+		wantErr     bool
+	}{
+		{
+			name:        "prefix",
+			evt:         `{"type": "b64:Um9vbQ==", "name": "Room"}`,
+			wantOut:     `{"type": "Room", "name": "Room"}`,
+			wantDecoded: []string{"type"},
+		},
+		{
+			name:        "companion array",
+			evt:         `{"_encoded": ["roleVisibility.Agent"], "roleVisibility": {"Agent": "Sm/FvmtvIE1ya3ZpxI1rw6EgMQ=="}}`,
+			wantOut:     `{"roleVisibility": {"Agent": "Jožko Mrkvičká 1"}}`,
+			wantDecoded: []string{"roleVisibility.Agent"},
+		},
+		{
+			name:    "not UTF-8",
+			evt:     `{"name": "Test", "city": "abcd"}`,
+			wantOut: `{"name": "Test", "city": "abcd"}`,
+		},
+		{
+			name:    "marked but not UTF-8",
+			evt:     `{"name": "b64:Test"}`,
+			wantErr: true,
+		},
+		{
+			name:        "strict with markers",
+			strict:      true,
+			evt:         `{"name": "b64:Sm/FvmtvIE1ya3ZpxI1rw6EgMg==", "city": "Room"}`,
+			wantOut:     `{"name": "Jožko Mrkvičká 2", "city": "Room"}`,
+			wantDecoded: []string{"name"},
+		},
+		{
+			name:    "strict ambiguous",
+			strict:  true,
+			evt:     `{"name": "Sm/FvmtvIE1ya3ZpxI1rw6EgMg=="}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf.Push.DecodingStrict = tt.strict
+			gotOut, gotDecoded, err := digest([]byte(tt.evt))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("digest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			var o1, o2 interface{}
+			json.Unmarshal(gotOut, &o1)
+			json.Unmarshal([]byte(tt.wantOut), &o2)
+			if !reflect.DeepEqual(o1, o2) {
+				t.Errorf("digest() = %v, want %v", o1, o2)
+			}
+			if fmt.Sprint(gotDecoded) != fmt.Sprint(tt.wantDecoded) {
+				t.Errorf("digest() decoded = %v, want %v", gotDecoded, tt.wantDecoded)
+			}
+		})
+	}
+}
+
+func Test_prepare(t *testing.T) {
+	if _, err := prepare(context.Background(), []byte(createUnitMessage)); err != nil {
+		t.Errorf("prepare() error = %v", err)
+	}
+	_, err := prepare(context.Background(), []byte(`{"actionType": "DEASSIGN_ROLE", "mefeAPIRequestId": "e7bb7494"}`))
+	if verr, ok := err.(*payload.ValidationError); !ok || verr.Field != "removeUserFromUnitRequestId" {
+		t.Errorf("prepare() error = %#v, want missing removeUserFromUnitRequestId", err)
+	}
+}
+
+func Test_messageAttributes(t *testing.T) {
+	defer func(c config.Config) { conf = c }(conf)
+	conf.Stage = "dev"
+	got := messageAttributes([]byte(createUnitMessage))
+	want := map[string]string{
+		"actionType":       "CREATE_UNIT",
+		"mefeAPIRequestId": "e7bb7494-bfa3-11e9-a563-06358cf32556",
+		"stage":            "dev",
+		"schemaVersion":    payload.SchemaVersion,
+	}
+	if len(got) != len(want) {
+		t.Errorf("messageAttributes() = %v, want %v", got, want)
+	}
+	for key, val := range want {
+		if got[key].StringValue == nil || *got[key].StringValue != val {
+			t.Errorf("messageAttributes()[%s] = %v, want %s", key, got[key], val)
+		}
+	}
+	if *got["schemaVersion"].DataType != "Number" {
+		t.Errorf("messageAttributes() schemaVersion DataType = %s", *got["schemaVersion"].DataType)
+	}
+}
+
+func Test_handler(t *testing.T) {
+	defer func(rt routingTable, c config.Config) { routes, conf = rt, c }(routes, conf)
+	routes = routingTable{Default: []string{"https://sqs/default.fifo"}}
+	conf.Push.FIFO = true
+	s := &memorySender{}
+	h := handler(s)
+	ctx := context.Background()
+
+	err := h(ctx, []byte(`{"actionType": "ASSIGN_ROLE", "unitId": "jAPsg5sZBjSDT9QSD", "mefeAPIRequestId": "e7bb7494", "idMapUserUnitPermission": 7, "roleVisibility": {"Agent": 1}, "name": "b64:Sm/FvmtvIE1ya3ZpxI1rw6EgMQ=="}`))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if err := h(ctx, []byte(`{"actionType": "ASSIGN_ROLE", "mefeAPIRequestId": "e7bb7494"}`)); err == nil {
+		t.Error("handler() wants error for a missing idMapUserUnitPermission")
+	}
+
+	sent := s.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("handler() sent %d messages, want 1", len(sent))
+	}
+	m := sent[0]
+	var body map[string]interface{}
+	json.Unmarshal([]byte(m.Body), &body)
+	if m.Destination != "https://sqs/default.fifo" || body["name"] != "Jožko Mrkvičká 1" {
+		t.Errorf("handler() sent %+v", m)
+	}
+	if m.Attributes["actionType"] != "ASSIGN_ROLE" || m.Attributes["mefeAPIRequestId"] != "e7bb7494" {
+		t.Errorf("handler() attributes = %v", m.Attributes)
+	}
+	if m.DeduplicationID != "e7bb7494" || m.GroupID != "unit-jAPsg5sZBjSDT9QSD" {
+		t.Errorf("handler() FIFO IDs = %s, %s", m.DeduplicationID, m.GroupID)
+	}
+}
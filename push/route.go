@@ -0,0 +1,96 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// route sends the messages whose attributes match every pattern to all its
+// destinations. Patterns are path.Match globs such as "CREATE_*".
+type route struct {
+	Match        map[string]string `json:"match"`
+	Destinations []string          `json:"destinations"`
+}
+
+// routingTable is read from the ROUTES setting, e.g.
+//
+//	{
+//	  "routes": [
+//	    {"match": {"notification_type": "case_*"}, "destinations": ["https://sqs...", "arn:aws:sns:..."]}
+//	  ],
+//	  "default": ["https://sqs..."]
+//	}
+//
+// A destination is an SQS queue URL or an SNS topic ARN.
+type routingTable struct {
+	Routes  []route  `json:"routes"`
+	Default []string `json:"default"`
+}
+
+// loadRoutes reads the ROUTES setting, without one everything goes to the defaultDestinations
+func loadRoutes(setting string) (rt routingTable, err error) {
+	if setting != "" {
+		err = payload.LoadSetting(setting, &rt)
+	}
+	if len(rt.Default) == 0 {
+		rt.Default = defaultDestinations()
+	}
+	return rt, err
+}
+
+// defaultDestinations are SQS_URL, and SNS_TOPIC_ARN when publishing to SNS
+func defaultDestinations() []string {
+	if conf.Push.TopicARN != "" {
+		return []string{conf.Push.QueueURL, conf.Push.TopicARN}
+	}
+	return []string{conf.Push.QueueURL}
+}
+
+// destinations returns the destinations of the first matching route, else the default route
+func (rt routingTable) destinations(attrs map[string]string) []string {
+	for _, r := range rt.Routes {
+		if r.matches(attrs) {
+			return r.Destinations
+		}
+	}
+	return rt.Default
+}
+
+func (r route) matches(attrs map[string]string) bool {
+	for key, pattern := range r.Match {
+		ok, err := path.Match(pattern, attrs[key])
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// routeAttributes are the message attributes routes match against
+func routeAttributes(input *sqs.SendMessageInput) map[string]string {
+	attrs := map[string]string{}
+	for key, attr := range input.MessageAttributes {
+		attrs[key] = aws.StringValue(attr.StringValue)
+	}
+	return attrs
+}
+
+func isSNS(dest string) bool {
+	return strings.HasPrefix(dest, "arn:aws:sns:")
+}
+
+// forDestination addresses a copy of input to an SQS queue. Only FIFO queues,
+// whose names end in .fifo, accept the deduplication and group IDs.
+func forDestination(input *sqs.SendMessageInput, dest string) *sqs.SendMessageInput {
+	out := *input
+	out.QueueUrl = aws.String(dest)
+	if !strings.HasSuffix(dest, ".fifo") {
+		out.MessageDeduplicationId = nil
+		out.MessageGroupId = nil
+	}
+	return &out
+}
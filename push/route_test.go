@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const testRoutes = `{
+	"routes": [
+		{"match": {"actionType": "CREATE_*"}, "destinations": ["https://sqs/create.fifo", "arn:aws:sns:ap-southeast-1:812644853088:created"]},
+		{"match": {"notification_type": "case_*", "bz_source_table": "ut_notification_message_new"}, "destinations": ["arn:aws:sns:ap-southeast-1:812644853088:messages"]}
+	],
+	"default": ["https://sqs/default"]
+}`
+
+func Test_routingTable(t *testing.T) {
+	rt, err := loadRoutes(testRoutes)
+	if err != nil {
+		t.Fatalf("loadRoutes() error = %v", err)
+	}
+	tests := []struct {
+		name  string
+		attrs map[string]string
+		want  []string
+	}{
+		{"fan out", map[string]string{"actionType": "CREATE_UNIT"}, []string{"https://sqs/create.fifo", "arn:aws:sns:ap-southeast-1:812644853088:created"}},
+		{"all patterns", map[string]string{"notification_type": "case_new_message", "bz_source_table": "ut_notification_message_new"}, []string{"arn:aws:sns:ap-southeast-1:812644853088:messages"}},
+		{"partial match", map[string]string{"notification_type": "case_updated", "bz_source_table": "ut_notification_case_updated"}, []string{"https://sqs/default"}},
+		{"unmatched", map[string]string{"actionType": "EDIT_UNIT"}, []string{"https://sqs/default"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rt.destinations(tt.attrs); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("destinations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	rt, err = loadRoutes("")
+	if err != nil || len(rt.Default) != 1 || rt.Default[0] != conf.Push.QueueURL {
+		t.Errorf("loadRoutes(\"\") = %v, %v, want SQS_URL", rt, err)
+	}
+}
+
+func Test_forDestination(t *testing.T) {
+	input := &sqs.SendMessageInput{
+		MessageBody:            aws.String(createUnitMessage),
+		MessageDeduplicationId: aws.String("e7bb7494-bfa3-11e9-a563-06358cf32556"),
+		MessageGroupId:         aws.String("unitCreationRequest-4771"),
+	}
+	if got := forDestination(input, "https://sqs/create.fifo"); got.MessageGroupId == nil || *got.QueueUrl != "https://sqs/create.fifo" {
+		t.Errorf("forDestination() FIFO = %+v", got)
+	}
+	if got := forDestination(input, "https://sqs/default"); got.MessageGroupId != nil || got.MessageDeduplicationId != nil {
+		t.Errorf("forDestination() standard = %+v", got)
+	}
+	if input.MessageGroupId == nil {
+		t.Error("forDestination() modified its input")
+	}
+}
+
+func Test_enqueueAllRoutes(t *testing.T) {
+	defer func(rt routingTable) { routes = rt }(routes)
+	var err error
+	routes, err = loadRoutes(testRoutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memorySender{}
+	msgs := []json.RawMessage{
+		[]byte(createUnitMessage),
+		[]byte(`{"notification_type": "case_new_message", "bz_source_table": "ut_notification_message_new", "notification_id": "ut_notification_message_new-4300"}`),
+		[]byte(`{"actionType": "EDIT_UNIT", "mefeAPIRequestId": "1", "updateUnitRequestId": 1054}`),
+	}
+	if err := enqueueAll(context.Background(), s, msgs); err != nil {
+		t.Fatalf("enqueueAll() error = %v", err)
+	}
+	var got []string
+	for _, m := range s.Sent() {
+		got = append(got, fmt.Sprintf("%s=%s", m.Destination, m.Attributes["actionType"]+m.Attributes["notification_type"]))
+	}
+	sort.Strings(got)
+	want := "[arn:aws:sns:ap-southeast-1:812644853088:created=CREATE_UNIT arn:aws:sns:ap-southeast-1:812644853088:messages=case_new_message https://sqs/create.fifo=CREATE_UNIT https://sqs/default=EDIT_UNIT]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("enqueueAll() sent %v, want %v", got, want)
+	}
+}
+
+// failingSender fails every message to the destinations in fail
+type failingSender struct {
+	*memorySender
+	fail map[string]bool
+}
+
+func (f failingSender) Send(ctx context.Context, dest string, input *sqs.SendMessageInput) error {
+	if f.fail[dest] {
+		return fmt.Errorf("%s is down", dest)
+	}
+	return f.memorySender.Send(ctx, dest, input)
+}
+
+func (f failingSender) SendBatch(ctx context.Context, dest string, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error) {
+	return sendEach(ctx, f, dest, entries)
+}
+
+func Test_enqueueFailedDestination(t *testing.T) {
+	defer func(rt routingTable, r *sentRecord) { routes, sent = rt, r }(routes, sent)
+	var err error
+	routes, err = loadRoutes(testRoutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, topic := "https://sqs/create.fifo", "arn:aws:sns:ap-southeast-1:812644853088:created"
+
+	t.Run("single", func(t *testing.T) {
+		sent = newSentRecord(time.Hour)
+		s := failingSender{&memorySender{}, map[string]bool{queue: true}}
+		err := enqueue(invocation("r1"), s, []byte(createUnitMessage))
+		if err == nil || !strings.Contains(err.Error(), queue+" is down") {
+			t.Fatalf("enqueue() error = %v", err)
+		}
+		if got := s.Sent(); len(got) != 1 || got[0].Destination != topic {
+			t.Errorf("enqueue() sent %+v, want the topic after the queue failed", got)
+		}
+		// Lambda retries once the queue is back, the topic is not sent to again
+		delete(s.fail, queue)
+		if err := enqueue(invocation("r1"), s, []byte(createUnitMessage)); err != nil {
+			t.Fatalf("enqueue() retry error = %v", err)
+		}
+		if got := s.Sent(); len(got) != 2 || got[1].Destination != queue {
+			t.Errorf("enqueue() retry sent %+v", got)
+		}
+	})
+	t.Run("batch", func(t *testing.T) {
+		defer func(d time.Duration) { batchBackoff = d }(batchBackoff)
+		batchBackoff = 0
+		sent = newSentRecord(time.Hour)
+		s := failingSender{&memorySender{}, map[string]bool{queue: true, topic: true}}
+		err := enqueueAll(context.Background(), s, []json.RawMessage{[]byte(createUnitMessage)})
+		f, ok := err.(*batchFailure)
+		if !ok || len(f.Failed["0"]) != 2 {
+			t.Fatalf("enqueueAll() error = %v, want both destinations of #0", err)
+		}
+		if !strings.Contains(err.Error(), queue) || !strings.Contains(err.Error(), topic) {
+			t.Errorf("enqueueAll() error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Sender delivers prepared messages to a destination, an SQS queue URL or SNS topic ARN
+type Sender interface {
+	Send(ctx context.Context, dest string, input *sqs.SendMessageInput) error
+	// SendBatch sends up to batchSize entries and returns the failed ones
+	SendBatch(ctx context.Context, dest string, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error)
+}
+
+// awsSender picks the SNS sender for topics and the SQS sender for queues
+type awsSender struct {
+	sqs Sender
+	sns Sender
+}
+
+func newAWSSender(cfg aws.Config) awsSender {
+	return awsSender{
+		sqs: sqsSender{sqs.New(cfg)},
+		sns: snsSender{sns.New(cfg)},
+	}
+}
+
+func (s awsSender) pick(dest string) Sender {
+	if isSNS(dest) {
+		return s.sns
+	}
+	return s.sqs
+}
+
+func (s awsSender) Send(ctx context.Context, dest string, input *sqs.SendMessageInput) error {
+	return s.pick(dest).Send(ctx, dest, input)
+}
+
+func (s awsSender) SendBatch(ctx context.Context, dest string, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error) {
+	return s.pick(dest).SendBatch(ctx, dest, entries)
+}
+
+// sqsSender sends to SQS queues
+type sqsSender struct {
+	svc *sqs.Client
+}
+
+func (s sqsSender) Send(ctx context.Context, queue string, input *sqs.SendMessageInput) error {
+	_, err := s.svc.SendMessageRequest(forDestination(input, queue)).Send(ctx)
+	return err
+}
+
+func (s sqsSender) SendBatch(ctx context.Context, queue string, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error) {
+	req := s.svc.SendMessageBatchRequest(&sqs.SendMessageBatchInput{
+		Entries:  entries,
+		QueueUrl: aws.String(queue),
+	})
+	res, err := req.Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.Failed, nil
+}
+
+// sentMessage is a message as a destination received it
+type sentMessage struct {
+	Destination     string            `json:"destination"`
+	Body            string            `json:"body"`
+	Attributes      map[string]string `json:"attributes,omitempty"`
+	DeduplicationID string            `json:"deduplicationId,omitempty"`
+	GroupID         string            `json:"groupId,omitempty"`
+}
+
+func newSentMessage(dest string, input *sqs.SendMessageInput) sentMessage {
+	if !isSNS(dest) {
+		input = forDestination(input, dest)
+	}
+	return sentMessage{
+		Destination:     dest,
+		Body:            aws.StringValue(input.MessageBody),
+		Attributes:      routeAttributes(input),
+		DeduplicationID: aws.StringValue(input.MessageDeduplicationId),
+		GroupID:         aws.StringValue(input.MessageGroupId),
+	}
+}
+
+// sendEach sends a batch message by message, for senders without a batch API
+func sendEach(ctx context.Context, s Sender, dest string, entries []sqs.SendMessageBatchRequestEntry) (failed []sqs.BatchResultErrorEntry, err error) {
+	for _, e := range entries {
+		err := s.Send(ctx, dest, &sqs.SendMessageInput{
+			MessageAttributes:      e.MessageAttributes,
+			MessageBody:            e.MessageBody,
+			MessageDeduplicationId: e.MessageDeduplicationId,
+			MessageGroupId:         e.MessageGroupId,
+		})
+		if err != nil {
+			failed = append(failed, sqs.BatchResultErrorEntry{
+				Id:          e.Id,
+				Code:        aws.String("SendFailed"),
+				Message:     aws.String(err.Error()),
+				SenderFault: aws.Bool(false),
+			})
+		}
+	}
+	return failed, nil
+}
+
+// memorySender records what it is sent, to test push without AWS
+type memorySender struct {
+	mu   sync.Mutex
+	sent []sentMessage
+}
+
+func (m *memorySender) Send(ctx context.Context, dest string, input *sqs.SendMessageInput) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, newSentMessage(dest, input))
+	return nil
+}
+
+func (m *memorySender) SendBatch(ctx context.Context, dest string, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error) {
+	return sendEach(ctx, m, dest, entries)
+}
+
+// Sent returns the messages sent so far
+func (m *memorySender) Sent() []sentMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sentMessage(nil), m.sent...)
+}
+
+// fileSender appends each message as a JSON line to a file per destination,
+// a stand-in queue for local development
+type fileSender struct {
+	dir string
+	mu  *sync.Mutex
+}
+
+func newFileSender(dir string) (fileSender, error) {
+	return fileSender{dir: dir, mu: &sync.Mutex{}}, os.MkdirAll(dir, 0755)
+}
+
+var unsafeFileName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// path names the file of a destination, e.g. https://sqs/queue becomes https_sqs_queue.jsonl
+func (s fileSender) path(dest string) string {
+	return filepath.Join(s.dir, unsafeFileName.ReplaceAllString(dest, "_")+".jsonl")
+}
+
+func (s fileSender) Send(ctx context.Context, dest string, input *sqs.SendMessageInput) error {
+	line, err := json.Marshal(newSentMessage(dest, input))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path(dest), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s fileSender) SendBatch(ctx context.Context, dest string, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error) {
+	return sendEach(ctx, s, dest, entries)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/text"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/unee-t/lambda2sqs/blobstore"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// serve runs push as a plain HTTP server for local development:
+//
+//	push serve -addr :8080 -queue ./queue
+//	curl -d @tests/events/create_unit.json localhost:8080
+//
+// Without -queue messages go to the real SQS queues and SNS topics.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "listen address")
+	dir := fs.String("queue", "", "directory of a file backed queue, instead of SQS & SNS")
+	fs.Parse(args)
+
+	log.SetHandler(text.New(os.Stderr))
+
+	var s Sender
+	if *dir != "" {
+		fileQueue, err := newFileSender(*dir)
+		if err != nil {
+			return err
+		}
+		s = fileQueue
+		blobs = payload.DirStore{Dir: filepath.Join(*dir, "blobs")}
+	} else {
+		cfg, err := external.LoadDefaultAWSConfig()
+		if err != nil {
+			return err
+		}
+		s = newAWSSender(cfg)
+		blobs = blobstore.New(cfg, conf.ClaimCheck)
+	}
+
+	log.WithFields(log.Fields{
+		"addr":  *addr,
+		"queue": *dir,
+	}).Info("serving")
+	return http.ListenAndServe(*addr, ingest(s))
+}
+
+// ingest enqueues POSTed payloads the same way the Lambda handler does
+func ingest(s Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST a JSON payload", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = enqueue(r.Context(), s, body)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch err.(type) {
+		case nil:
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]bool{"enqueued": true})
+		case *batchFailure:
+			w.WriteHeader(http.StatusMultiStatus)
+			json.NewEncoder(w).Encode(err)
+		case *payload.ValidationError, *payload.DecodeError:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(err)
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"reason": err.Error()})
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]string{"reason": err.Error()})
+		}
+	}
+}
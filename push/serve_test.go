@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_ingest(t *testing.T) {
+	defer func(rt routingTable) { routes = rt }(routes)
+	routes = routingTable{Default: []string{"https://sqs/default"}}
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := newFileSender(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(ingest(s))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"valid", "POST", createUnitMessage, http.StatusAccepted},
+		// Posting the same payload again sends it again
+		{"valid again", "POST", createUnitMessage, http.StatusAccepted},
+		{"batch", "POST", `{"messages": [{"name": "x"}, {"city": "Room"}]}`, http.StatusAccepted},
+		{"invalid", "POST", `{"actionType": "CREATE_UNIT", "mefeAPIRequestId": "e7bb7494"}`, http.StatusBadRequest},
+		{"not JSON", "POST", `not even JSON`, http.StatusBadRequest},
+		{"partial batch", "POST", `[{"name": "x"}, {"actionType": "EDIT_UNIT"}]`, http.StatusMultiStatus},
+		{"GET", "GET", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL, strings.NewReader(tt.body))
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				body, _ := ioutil.ReadAll(res.Body)
+				t.Errorf("status = %d, want %d: %s", res.StatusCode, tt.wantStatus, body)
+			}
+		})
+	}
+
+	f, err := os.Open(s.path("https://sqs/default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+	}
+	if lines != 5 {
+		t.Errorf("queue has %d messages, want 5", lines)
+	}
+}
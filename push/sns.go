@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+// SNS_TOPIC_ARN publishes every message to the topic as well as to SQS_URL,
+// for microservices that only want some notification types. They subscribe
+// with a filter policy on the message attributes, e.g.
+//
+//	{"notification_type": ["case_new_message"], "stage": ["prod"]}
+//	{"messageType": ["actionType"], "unit_id": [{"numeric": ["=", 2203]}]}
+
+// Message types for the messageType filter attribute
+const (
+	messageTypeAction       = "actionType"
+	messageTypeNotification = "notification"
+)
+
+// publishInput turns a prepared message into an SNS publish
+func publishInput(input *sqs.SendMessageInput, topic string) *sns.PublishInput {
+	return &sns.PublishInput{
+		Message:           input.MessageBody,
+		MessageAttributes: filterAttributes(input),
+		TopicArn:          aws.String(topic),
+	}
+}
+
+// filterAttributes adds to the SQS message attributes the ones worth filtering on in SNS
+func filterAttributes(input *sqs.SendMessageInput) map[string]sns.MessageAttributeValue {
+	attrs := map[string]sns.MessageAttributeValue{}
+	for key, attr := range input.MessageAttributes {
+		attrs[key] = sns.MessageAttributeValue{
+			DataType:    attr.DataType,
+			StringValue: attr.StringValue,
+		}
+	}
+	if _, ok := attrs[payload.AttrActionType]; ok {
+		attrs[payload.AttrMessageType] = sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(messageTypeAction),
+		}
+	} else if _, ok := attrs[payload.AttrNotificationType]; ok {
+		attrs[payload.AttrMessageType] = sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(messageTypeNotification),
+		}
+	}
+
+	// Entity IDs as numbers, so policies can match numerically
+	d := json.NewDecoder(bytes.NewReader([]byte(aws.StringValue(input.MessageBody))))
+	d.UseNumber()
+	var rec map[string]interface{}
+	if d.Decode(&rec) != nil {
+		return attrs
+	}
+	for _, key := range []string{payload.AttrUnitID, payload.AttrCaseID} {
+		v := field(rec, key)
+		if _, err := json.Number(v).Int64(); v == "" || err != nil {
+			continue
+		}
+		attrs[key] = sns.MessageAttributeValue{
+			DataType:    aws.String("Number"),
+			StringValue: aws.String(v),
+		}
+	}
+	return attrs
+}
+
+// snsSender publishes to SNS topics
+type snsSender struct {
+	svc *sns.Client
+}
+
+func (s snsSender) Send(ctx context.Context, topic string, input *sqs.SendMessageInput) error {
+	_, err := s.svc.PublishRequest(publishInput(input, topic)).Send(ctx)
+	return err
+}
+
+// SendBatch publishes entries one by one, as SNS has no batch publish
+func (s snsSender) SendBatch(ctx context.Context, topic string, entries []sqs.SendMessageBatchRequestEntry) ([]sqs.BatchResultErrorEntry, error) {
+	return sendEach(ctx, s, topic, entries)
+}
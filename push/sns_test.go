@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/unee-t/lambda2sqs/config"
+)
+
+func Test_filterAttributes(t *testing.T) {
+	tests := []struct {
+		name string
+		evt  string
+		want map[string]string
+	}{
+		{
+			name: "notification",
+			evt:  `{"notification_type": "case_new_message", "bz_source_table": "ut_notification_message_new", "notification_id": "ut_notification_message_new-4300", "unit_id": "2203", "case_id": 3293}`,
+			want: map[string]string{
+				"notification_type": "String:case_new_message",
+				"bz_source_table":   "String:ut_notification_message_new",
+				"messageType":       "String:notification",
+				"unit_id":           "Number:2203",
+				"case_id":           "Number:3293",
+				"schemaVersion":     "Number:1",
+			},
+		},
+		{
+			name: "actionType",
+			evt:  createUnitMessage,
+			want: map[string]string{
+				"actionType":       "String:CREATE_UNIT",
+				"mefeAPIRequestId": "String:e7bb7494-bfa3-11e9-a563-06358cf32556",
+				"messageType":      "String:actionType",
+				"schemaVersion":    "Number:1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := sendMessageInput(json.RawMessage(tt.evt), json.RawMessage(tt.evt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for key, attr := range publishInput(input, "arn:aws:sns:ap-southeast-1:812644853088:atest").MessageAttributes {
+				got[key] = aws.StringValue(attr.DataType) + ":" + aws.StringValue(attr.StringValue)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("filterAttributes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_defaultDestinations(t *testing.T) {
+	defer func(c config.Config) { conf = c }(conf)
+	conf.Push.QueueURL, conf.Push.TopicARN = "https://sqs/default", ""
+	if got := defaultDestinations(); len(got) != 1 || got[0] != "https://sqs/default" {
+		t.Errorf("defaultDestinations() = %v, want SQS_URL", got)
+	}
+	conf.Push.TopicARN = "arn:aws:sns:ap-southeast-1:812644853088:atest"
+	if got := defaultDestinations(); len(got) != 2 || got[1] != conf.Push.TopicARN {
+		t.Errorf("defaultDestinations() = %v, want SQS_URL and SNS_TOPIC_ARN", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/unee-t/lambda2sqs/payload"
+)
+
+func Test_digestSpec(t *testing.T) {
+	defer func(s payload.DecodingSpec) { spec = s }(spec)
+	var err error
+	spec, err = payload.LoadDecodingSpec(`{
+		"ASSIGN_ROLE": ["roleVisibility.*"],
+		"case_new_message": ["invitees.*.name", "tags.1"]
+	}`)
+	if err != nil {
+		t.Fatalf("LoadDecodingSpec() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		evt     string
+		wantOut string
+	}{
+		{
+			name:    "nested object",
+			evt:     `{"actionType": "ASSIGN_ROLE", "roleVisibility": {"Agent": "Sm/FvmtvIE1ya3ZpxI1rw6EgMQ==", "Tenant": 1}, "name": "Sm/FvmtvIE1ya3ZpxI1rw6EgMQ=="}`,
+			wantOut: `{"actionType": "ASSIGN_ROLE", "roleVisibility": {"Agent": "Jožko Mrkvičká 1", "Tenant": 1}, "name": "Sm/FvmtvIE1ya3ZpxI1rw6EgMQ=="}`,
+		},
+		{
+			name:    "array elements",
+			evt:     `{"notification_type": "case_new_message", "invitees": [{"name": "Sm/FvmtvIE1ya3ZpxI1rw6EgMQ=="}], "tags": ["Sm/FvmtvIE1ya3ZpxI1rw6EgMQ==", "Sm/FvmtvIE1ya3ZpxI1rw6EgMg=="]}`,
+			wantOut: `{"notification_type": "case_new_message", "invitees": [{"name": "Jožko Mrkvičká 1"}], "tags": ["Sm/FvmtvIE1ya3ZpxI1rw6EgMQ==", "Jožko Mrkvičká 2"]}`,
+		},
+		{
+			name:    "other type",
+			evt:     `{"actionType": "CREATE_UNIT", "roleVisibility": {"Agent": "Sm/FvmtvIE1ya3ZpxI1rw6EgMQ=="}}`,
+			wantOut: `{"actionType": "CREATE_UNIT", "roleVisibility": {"Agent": "Sm/FvmtvIE1ya3ZpxI1rw6EgMQ=="}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotOut, _, err := digest(json.RawMessage(tt.evt))
+			if err != nil {
+				t.Fatalf("digest() error = %v", err)
+			}
+			var o1, o2 interface{}
+			json.Unmarshal(gotOut, &o1)
+			json.Unmarshal([]byte(tt.wantOut), &o2)
+			if !reflect.DeepEqual(o1, o2) {
+				t.Errorf("digest() = %v, want %v", o1, o2)
+			}
+		})
+	}
+}